@@ -0,0 +1,149 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/lukehinds/sap/pkg/bundle"
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// bundleCmd represents the bundle command
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "sap bundle packs signed scripts for offline installs",
+	Long: `A bundle is a single file holding a script, its signature, certificate
+chain, Rekor entry with inclusion proof and the signed manifest. Install it
+on a host without network access with sap install --bundle file, which
+verifies it offline against the locally configured trust roots.`,
+}
+
+var bundleExportCmd = &cobra.Command{
+	Use:   "export [script]",
+	Short: "Write a script and its signed materials to a bundle file",
+	Long: `Read a signed script and its materials from the forge, at the revision
+given with --tag, --ref or --commit, or from a local checkout given with
+--dir, and write them to a bundle file.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+		var b *bundle.Bundle
+		if dir := viper.GetString("dir"); dir != "" {
+			manifestPath, err := checkoutManifest(dir, name)
+			if err != nil {
+				return err
+			}
+			b = &bundle.Bundle{Manifest: manifestPath, Files: map[string][]byte{}}
+			materials, err := loadMaterials(recordingReader(checkoutReader(dir), b.Files), b.Manifest, name)
+			if err != nil {
+				return err
+			}
+			b.Script = materials.Artifact.Path
+		} else {
+			loc, err := forgeLocation()
+			if err != nil {
+				return err
+			}
+			if viper.GetString("ref") != "" && viper.GetString("commit") != "" {
+				return fmt.Errorf("--ref and --commit can not be used together")
+			}
+			store, err := newForge(false)
+			if err != nil {
+				return err
+			}
+			sha, err := store.ResolveCommit(ctx, viper.GetString("tag"), viper.GetString("ref"), viper.GetString("commit"))
+			if err != nil {
+				return err
+			}
+			manifestPath, err := forgeManifest(store, sha, name)
+			if err != nil {
+				return err
+			}
+			if b, err = forgeBundle(store, loc, sha, manifestPath, name); err != nil {
+				return err
+			}
+		}
+
+		output := viper.GetString("output")
+		if output == "" {
+			output = path.Base(b.Script) + ".sapbundle"
+		}
+		if err := b.Write(output); err != nil {
+			return err
+		}
+		pterm.Success.Println("Wrote " + b.Script + " and its materials to " + output)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bundleCmd)
+	bundleCmd.AddCommand(bundleExportCmd)
+	addRevisionFlags(bundleExportCmd.Flags())
+	bundleExportCmd.Flags().String("dir", "", "Export from a local checkout instead of the forge")
+	bundleExportCmd.Flags().StringP("output", "o", "", "Bundle file to write, defaults to <script>.sapbundle")
+}
+
+// forgeBundle reads the named script and its materials as of commit sha
+// from the forge into a bundle.
+func forgeBundle(store forge.Forge, loc forge.Location, sha, manifestPath, name string) (*bundle.Bundle, error) {
+	b := &bundle.Bundle{
+		Repo:     loc.ID(),
+		Owner:    loc.Owner,
+		Name:     loc.Repo,
+		Commit:   sha,
+		Manifest: manifestPath,
+		Files:    map[string][]byte{},
+	}
+	dir, err := utils.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	materials, err := loadMaterials(recordingReader(forgeReader(store, sha, dir), b.Files), manifestPath, name)
+	if err != nil {
+		return nil, err
+	}
+	b.Script = materials.Artifact.Path
+	return b, nil
+}
+
+// bundleReader reads the materials from a bundle.
+func bundleReader(b *bundle.Bundle) materialReader {
+	return func(repoPath string, sha256 string) ([]byte, error) {
+		content, err := b.Read(repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if sha256 != "" {
+			if err := download.Check(content, download.Digests{SHA256: sha256}); err != nil {
+				return nil, fmt.Errorf("bundled %s: %w", repoPath, err)
+			}
+		}
+		return content, nil
+	}
+}
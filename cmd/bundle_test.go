@@ -0,0 +1,59 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/bundle"
+)
+
+func TestBundleReader(t *testing.T) {
+	script := []byte("echo hi\n")
+	digest := sha256.Sum256(script)
+	read := bundleReader(&bundle.Bundle{Files: map[string][]byte{"install.sh": script}})
+
+	tests := []struct {
+		name   string
+		path   string
+		sha256 string
+		err    bool
+	}{
+		{name: "matching digest", path: "install.sh", sha256: hex.EncodeToString(digest[:])},
+		{name: "no digest", path: "install.sh"},
+		{name: "digest mismatch", path: "install.sh", sha256: hex.EncodeToString(make([]byte, sha256.Size)), err: true},
+		{name: "not bundled", path: "other.sh", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := read(tt.path, tt.sha256)
+			if tt.err {
+				if err == nil {
+					t.Error("read succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != string(script) {
+				t.Errorf("read = %q", content)
+			}
+		})
+	}
+}
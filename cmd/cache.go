@@ -0,0 +1,266 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lukehinds/sap/pkg/cache"
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/verify"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "sap cache inspects the cache of verified materials",
+	Long: `install keeps the materials of every script it verified in a cache, keyed
+by repository and commit SHA, with files stored by content hash. Installing
+the same commit again reads the materials from the cache and verifies them
+again offline instead of downloading them.
+
+The cache lives in $XDG_CACHE_HOME/sap unless --cache-dir is given.`,
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the cached repository commits",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		entries, err := c.Entries()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			fmt.Printf("%s\t%s\t%s\t%s\n", e.Repo, shortSHA(e.Commit), strings.Join(e.Scripts, ","), e.VerifiedAt.Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached commits and unreferenced or corrupt files",
+	Long: `Remove the cached commits selected by --all and --older-than, limited to
+one repository when --repo (with --owner), --repo-url or --local-repo is
+given. Files no remaining commit refers to, and files whose content no longer
+matches their hash, are always removed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := openCache()
+		if err != nil {
+			return err
+		}
+		all := viper.GetBool("all")
+		olderThan := viper.GetDuration("older-than")
+		var repoID string
+		if viper.GetString("repo") != "" || viper.GetString("repo-url") != "" || viper.GetString("local-repo") != "" {
+			loc, err := forgeLocation()
+			if err != nil {
+				return err
+			}
+			repoID = loc.ID()
+		}
+		entries, objects, err := c.Prune(func(e *cache.Entry) bool {
+			if repoID != "" && e.Repo != repoID {
+				return false
+			}
+			if olderThan > 0 {
+				return time.Since(e.VerifiedAt) > olderThan
+			}
+			return all || repoID != ""
+		})
+		if err != nil {
+			return err
+		}
+		pterm.Info.Printfln("Removed %d commits and %d files", entries, objects)
+		return nil
+	},
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the cache and verify every cached script again",
+	Long: `Re-hash every cached file, then run the signature, certificate, identity
+and inclusion proof checks on every cached script again, offline. The exit
+code is non-zero when any file is corrupt or any script fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(verifyCache())
+	},
+}
+
+// verifyCache checks every cached script and returns the exit code.
+func verifyCache() int {
+	c, err := openCache()
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+	code := 0
+	problems, err := c.Check()
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+	for _, p := range problems {
+		pterm.Error.Println(p)
+		code = verify.ClassMaterials.ExitCode()
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+	for _, e := range entries {
+		opts, err := verifyOptions(e.Owner, e.Name, false)
+		if err != nil {
+			pterm.Error.Println(err)
+			return 1
+		}
+		for _, script := range e.Scripts {
+			pterm.Info.Println("Verifying " + e.Repo + "@" + shortSHA(e.Commit) + " " + script)
+			materials, err := loadMaterials(cacheReader(c, e), e.ManifestOf(script), script)
+			if err != nil {
+				pterm.Error.Println(err)
+				code = verify.ClassMaterials.ExitCode()
+				continue
+			}
+			report := verify.Verify(materials, opts)
+			printReport(script, report)
+			if failure := report.Failure(); failure != nil {
+				code = failure.Class.ExitCode()
+			}
+		}
+	}
+	return code
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheLsCmd, cachePruneCmd, cacheVerifyCmd)
+	cachePruneCmd.Flags().Bool("all", false, "Remove every cached commit")
+	cachePruneCmd.Flags().Duration("older-than", 0, "Remove commits verified longer ago than this, for example 720h")
+	addVerifyFlags(cacheVerifyCmd.Flags())
+}
+
+// openCache opens the cache in --cache-dir.
+func openCache() (*cache.Cache, error) {
+	dir := viper.GetString("cache-dir")
+	if dir == "" {
+		var err error
+		if dir, err = cache.Dir(); err != nil {
+			return nil, err
+		}
+	}
+	return cache.Open(dir)
+}
+
+// cacheReader reads the materials of entry e from the cache.
+func cacheReader(c *cache.Cache, e *cache.Entry) materialReader {
+	return func(repoPath string, sha256 string) ([]byte, error) {
+		b, err := c.Read(e, repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if sha256 != "" {
+			if err := download.Check(b, download.Digests{SHA256: sha256}); err != nil {
+				return nil, fmt.Errorf("cached %s: %w", repoPath, err)
+			}
+		}
+		return b, nil
+	}
+}
+
+// recordingReader keeps a copy of everything read in files, so it can be
+// cached once verification passed.
+func recordingReader(read materialReader, files map[string][]byte) materialReader {
+	return func(repoPath string, sha256 string) ([]byte, error) {
+		b, err := read(repoPath, sha256)
+		if err == nil {
+			files[repoPath] = b
+		}
+		return b, err
+	}
+}
+
+// cachedMaterials returns the cached materials of the named script of repo
+// at commit sha, or nil when the script was not verified at that commit
+// before. The cache is only a shortcut, so problems reading it are reported
+// and otherwise ignored.
+func cachedMaterials(c *cache.Cache, repoID, sha, name string) *verify.Materials {
+	e, err := c.Lookup(repoID, sha)
+	if err != nil {
+		pterm.Warning.Println("Ignoring the cache: ", err)
+		return nil
+	}
+	if e == nil {
+		return nil
+	}
+	script, ok := e.Find(name)
+	if !ok {
+		return nil
+	}
+	materials, err := loadMaterials(cacheReader(c, e), e.ManifestOf(script), script)
+	if errors.Is(err, cache.ErrNotCached) {
+		return nil
+	}
+	if err != nil {
+		pterm.Warning.Println("Ignoring the cache: ", err)
+		return nil
+	}
+	if materials.Artifact.Path != script {
+		return nil
+	}
+	return materials
+}
+
+// writeScript writes a cached or bundled script to file so it can be run
+// like a downloaded one.
+func writeScript(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return download.WriteFile(file, content)
+}
+
+var commitSHAPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// isCommitSHA reports whether s is a full commit SHA, which can be looked
+// up in the cache without asking the forge what it resolves to.
+func isCommitSHA(s string) bool {
+	return commitSHAPattern.MatchString(s)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
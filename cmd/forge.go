@@ -0,0 +1,133 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// forgeTokenEnv maps each forge to the environment variable holding its token.
+var forgeTokenEnv = map[string]string{
+	forge.KindGitHub: "GITHUB_AUTH_TOKEN",
+	forge.KindGitLab: "GITLAB_TOKEN",
+	forge.KindGitea:  "GITEA_TOKEN",
+}
+
+// forgeLocation works out which repository to use from --repo-url, or from
+// --local-repo, --forge, --forge-url, --owner and --repo.
+func forgeLocation() (forge.Location, error) {
+	if repoURL := viper.GetString("repo-url"); repoURL != "" {
+		loc, err := forge.ParseURL(repoURL)
+		if err == nil && loc.Kind == forge.KindGitHub {
+			loc.BaseURL = viper.GetString("github-url")
+		}
+		return loc, err
+	}
+	if dir := viper.GetString("local-repo"); dir != "" {
+		return forge.Location{Kind: forge.KindLocal, Dir: dir}, nil
+	}
+	loc := forge.Location{
+		Kind:    viper.GetString("forge"),
+		BaseURL: viper.GetString("forge-url"),
+		Owner:   viper.GetString("owner"),
+		Repo:    viper.GetString("repo"),
+	}
+	if loc.Kind == "" {
+		loc.Kind = forge.KindGitHub
+	}
+	if githubURL := viper.GetString("github-url"); githubURL != "" && loc.Kind == forge.KindGitHub {
+		loc.BaseURL = githubURL
+	}
+	return loc, nil
+}
+
+// newForge returns the repository the signed materials live in.
+func newForge(requireToken bool) (forge.Forge, error) {
+	loc, err := forgeLocation()
+	if err != nil {
+		return nil, err
+	}
+	return openForge(loc, requireToken)
+}
+
+// openForge returns the repository at loc.
+func openForge(loc forge.Location, requireToken bool) (forge.Forge, error) {
+	if loc.Kind == forge.KindLocal {
+		return forge.NewLocalGit(loc.Dir)
+	}
+	if loc.Owner == "" || loc.Repo == "" {
+		return nil, errors.New("no repository given, set --owner and --repo or --repo-url")
+	}
+
+	tokenEnv, ok := forgeTokenEnv[loc.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported forge %q", loc.Kind)
+	}
+	token := os.Getenv(tokenEnv)
+	if token == "" && requireToken {
+		return nil, fmt.Errorf("unauthorized: No token present in %s", tokenEnv)
+	}
+
+	switch loc.Kind {
+	case forge.KindGitLab:
+		return forge.NewGitLab(nil, loc.BaseURL, token, loc.Owner, loc.Repo), nil
+	case forge.KindGitea:
+		if loc.BaseURL == "" {
+			return nil, errors.New("gitea needs the address of the instance, set --forge-url")
+		}
+		return forge.NewGitea(nil, loc.BaseURL, token, loc.Owner, loc.Repo), nil
+	}
+
+	client, err := newGitHubClient(loc.BaseURL, token)
+	if err != nil {
+		return nil, err
+	}
+	return forge.NewGitHub(client, loc.Owner, loc.Repo), nil
+}
+
+// newGitHubClient returns a client for github.com, or for the GitHub
+// Enterprise Server at baseURL when one is given.
+func newGitHubClient(baseURL string, token string) (*github.Client, error) {
+	var tc *http.Client
+	if token != "" {
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: token},
+		)
+		tc = oauth2.NewClient(ctx, ts)
+	}
+	if baseURL == "" {
+		return github.NewClient(tc), nil
+	}
+
+	// The upload API lives on the same host unless configured otherwise
+	uploadURL := viper.GetString("github-upload-url")
+	if uploadURL == "" {
+		uploadURL = baseURL
+	}
+	client, err := github.NewEnterpriseClient(baseURL, uploadURL, tc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GitHub Enterprise client for %s: %w", baseURL, err)
+	}
+	return client, nil
+}
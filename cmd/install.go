@@ -16,181 +16,422 @@
 package cmd
 
 import (
-	"crypto/ecdsa"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/asn1"
-	"encoding/base64"
-	"encoding/pem"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"math/big"
 	"os"
-	"path/filepath"
+	"os/exec"
+	"strings"
+	"time"
 
+	"github.com/lukehinds/sap/pkg/bundle"
+	"github.com/lukehinds/sap/pkg/cache"
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/idtoken"
+	"github.com/lukehinds/sap/pkg/lock"
 	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/verify"
 	"github.com/spf13/viper"
-	"golang.org/x/oauth2"
 
-	"github.com/google/go-github/v35/github"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 )
 
-// ecdsaSig is a datatype for a ECDSA Signature
-type ecdsaSig struct {
-	R *big.Int
-	S *big.Int
-}
-
 // installCmd represents the install command
 var installCmd = &cobra.Command{
-	Use:   "install",
+	Use:   "install [script] [-- args...]",
 	Short: "sap install a script",
-	Long:  `Securely retrieve and install a script from a GitHub repository.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		tag := viper.GetString("tag")
-		owner := viper.GetString("owner")
-		repo := viper.GetString("repo")
-		pterm.Info.Println("Running sap crypto downloader")
-
-		// lets see if we cannot use gh token and keep it for sign
-		// TODO Remove this
-		token := os.Getenv("GITHUB_AUTH_TOKEN")
-		if token == "" {
-			fmt.Printf("unauthorized: No token present")
-		}
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)
-		tc := oauth2.NewClient(ctx, ts)
-
-		// Ontain instance of google/go-github client
-		ghClient := github.NewClient(tc)
-		//ctx := context.Background()
-		//ghClient := github.NewClient(nil)
-		// TODO remove this
+	Long: `Securely retrieve and install a script from a git forge (GitHub, GitLab, Gitea) or a local git repository.
 
-		getFiles, _ := pterm.DefaultSpinner.Start("Retrieving signed materials and target script for tag: ", tag)
+When a release contains several signed scripts, name the one to run by its
+path or file name. Arguments after -- are passed to the script.
 
-		// Get the latest release tag
-		getLatestRelease, getLatestReleaseResp, err := ghClient.Repositories.GetLatestRelease(ctx, owner, repo)
-		if err != nil {
-			fmt.Printf("Repositories.GetLatestRelease returned error: %v\n%v", err, getLatestReleaseResp.Body)
+The script gets the caller's environment without the forge tokens, the
+identity token variables and SAP_PASSWORD, narrow it with --env-allow and
+--env-deny.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			args = args[:dash]
 		}
+		return cobra.MaximumNArgs(1)(cmd, args)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(install(cmd, args))
+	},
+}
 
-		switch getLatestReleaseResp.StatusCode {
-		case 403:
-			//fmt.Println(err)
-			getFiles.Fail("GitHub rate limit active ", getLatestReleaseResp.Rate)
+// install fetches, verifies and runs the script and returns the exit code.
+// Everything it downloads is removed when it returns.
+func install(cmd *cobra.Command, args []string) int {
+	tag := viper.GetString("tag")
+	ref := viper.GetString("ref")
+	commitSHA := viper.GetString("commit")
+	owner := viper.GetString("owner")
+	repo := viper.GetString("repo")
+	var name string
+	var scriptArgs []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		args, scriptArgs = args[:dash], args[dash:]
+	}
+	if len(args) > 0 {
+		name = args[0]
+	}
+	workdir := viper.GetString("workdir")
+	if workdir != "" {
+		if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+			pterm.Error.Println("--workdir " + workdir + " is not a directory")
+			return 1
 		}
+	}
+	pterm.Info.Println("Running sap crypto downloader")
 
-		// Get the sha for the latest release commit
-		release, resp, err := ghClient.Repositories.GetReleaseByTag(ctx, owner, repo, *getLatestRelease.TagName)
-		if err != nil {
-			fmt.Printf("Repositories.GetReleaseByTag returned error: %v\n%v", err, resp.Body)
+	// A bundle brings its own materials and is verified without the network
+	var b *bundle.Bundle
+	if file := viper.GetString("bundle"); file != "" {
+		var err error
+		if b, err = bundle.Load(file); err != nil {
+			pterm.Error.Println(err)
+			return 1
 		}
-
-		// get the commit that was used as a tag against the release, this then allows us to iterate
-		// over the files in the release / commit
-		commits, getCommitresp, err := ghClient.Repositories.GetCommit(ctx, owner, repo, *release.TargetCommitish)
-		if err != nil {
-			getFiles.Fail(err)
-			//fmt.Println(err)
+		// Nothing in the bundle is trusted, the repository whose identity
+		// policy applies is named by the installer
+		explicitPolicy := len(viper.GetStringSlice("allowed-identity")) > 0 || len(viper.GetStringSlice("allowed-issuer")) > 0
+		if (owner == "" || repo == "") && !explicitPolicy {
+			pterm.Error.Println("--bundle needs --owner and --repo to pick the identity policy, or --allowed-identity or --allowed-issuer")
+			return 1
+		}
+		if (owner != "" && b.Owner != "" && !strings.EqualFold(owner, b.Owner)) || (repo != "" && b.Name != "" && !strings.EqualFold(repo, b.Name)) {
+			pterm.Error.Println("Bundle " + file + " is for " + b.Repo + ", not " + owner + "/" + repo)
+			return 1
 		}
-		if getCommitresp.StatusCode != 200 {
-			getFiles.Fail("Network connection failed with http code: ", resp.StatusCode)
-			//fmt.Println(err)
+		if name == "" {
+			name = b.Script
 		}
+	}
 
-		// Gather files we need for verification
-		var scriptName string
-		var scriptPrettyName string
-		var certName string
-		var sigName string
+	opts, err := verifyOptions(owner, repo, b == nil)
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+	// Without the forge there is nothing else tying the bundle to the
+	// repository, so a keyless bundle needs a signer policy
+	if b != nil && opts.Policy.Empty() && opts.PublicKey == nil {
+		pterm.Error.Println("No identity policy configured for " + owner + "/" + repo + ", refusing to install a bundle signed by anyone")
+		return 1
+	}
 
-		for _, changeCommits := range commits.Files {
-			// we need these for being able to access them later
-			switch filepath.Ext(*changeCommits.Filename) {
-			case ".pem":
-				certName = "/tmp/" + filepath.Base(*changeCommits.Filename)
-			case ".bin":
-				sigName = "/tmp/" + filepath.Base(*changeCommits.Filename)
-			case ".sh":
-				scriptName = "/tmp/" + filepath.Base(*changeCommits.Filename)
-				scriptPrettyName = *changeCommits.Filename
-			}
-			err := utils.DownloadFile("/tmp/"+filepath.Base(*changeCommits.Filename), *changeCommits.RawURL)
-			if err != nil {
-				getFiles.Fail(err)
-			}
+	loc, err := forgeLocation()
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+	// A locked install runs the locked commit unless told otherwise, and
+	// nothing but what was locked
+	var locked *lock.Script
+	if viper.GetBool("locked") {
+		// The repository a bundle names is not trusted, the lock entry is
+		// found by the repository the installer names
+		if b != nil && (owner == "" || repo == "") {
+			pterm.Error.Println("--bundle with --locked needs --owner and --repo to find the locked script")
+			return 1
+		}
+		if locked, err = lockedScript(loc.ID(), name); err != nil {
+			pterm.Error.Println(err)
+			return 1
+		}
+		if b != nil && !strings.EqualFold(b.Commit, locked.Commit) {
+			pterm.Error.Println("Bundle " + viper.GetString("bundle") + " is of commit " + b.Commit + ", the lock file pins " + locked.Commit)
+			return 1
+		}
+		if name == "" {
+			name = locked.Path
 		}
+		if b == nil && commitSHA == "" && ref == "" && !cmd.Flags().Changed("tag") {
+			commitSHA = locked.Commit
+		}
+	}
 
-		getFiles.Success()
+	var c *cache.Cache
+	if b == nil && !viper.GetBool("no-cache") {
+		if c, err = openCache(); err != nil {
+			pterm.Warning.Println("Not using the cache: ", err)
+		}
+	}
 
-		// Verify the signature
-		verifySigning, _ := pterm.DefaultSpinner.Start("Performing signing verification  of " + scriptPrettyName)
+	revision := tag
+	switch {
+	case commitSHA != "":
+		revision = commitSHA
+	case ref != "":
+		revision = ref
+	case b != nil:
+		revision = viper.GetString("bundle")
+	}
+	getFiles, _ := pterm.DefaultSpinner.Start("Retrieving signed materials and target script for: ", revision)
 
-		certFile, err := utils.ReadFile(certName)
-		if err != nil {
-			fmt.Printf("certfile read error: %s ", err)
+	// A cached commit needs no forge at all
+	var (
+		store     forge.Forge
+		sha       string
+		materials *verify.Materials
+	)
+	switch {
+	case b != nil:
+		sha = b.Commit
+		if materials, err = loadMaterials(bundleReader(b), b.Manifest, name); err != nil {
+			getFiles.Fail(err)
+			return 1
+		}
+	case c != nil && isCommitSHA(commitSHA):
+		sha = commitSHA
+		materials = cachedMaterials(c, loc.ID(), sha, name)
+	}
+	if materials == nil {
+		// A token is optional for install but raises the forge rate limit
+		if store, err = newForge(false); err != nil {
+			getFiles.Fail(err)
+			return 1
 		}
+		// Pin whatever was asked for to a concrete commit so the run can be reproduced
+		if sha, err = store.ResolveCommit(ctx, tag, ref, commitSHA); err != nil {
+			getFiles.Fail(err)
+			return 1
+		}
+		if c != nil {
+			materials = cachedMaterials(c, loc.ID(), sha, name)
+		}
+	}
+	// Materials that were not downloaded are written out for the script to
+	// run from
+	local := materials != nil
 
-		// Extract the public key from the signing cert as we need this to verify
-		block, _ := pem.Decode(certFile)
-		var cert *x509.Certificate
-		cert, _ = x509.ParseCertificate(block.Bytes)
-		ecdsaPublicKey := cert.PublicKey.(*ecdsa.PublicKey)
+	dir, err := utils.TempDir()
+	if err != nil {
+		getFiles.Fail(err)
+		return 1
+	}
+	defer func() {
+		if viper.GetBool("keep") {
+			pterm.Info.Println("Keeping downloaded materials in " + dir)
+			return
+		}
+		os.RemoveAll(dir)
+	}()
 
-		// Generate the sha256hash of the artifact
-		hash := sha256.New()
-		in, err := os.Open(scriptName)
-		if err != nil {
-			fmt.Printf("failed to open: %s", err)
+	var manifestPath string
+	fetched := map[string][]byte{}
+	if !local {
+		// The manifest is the only source of truth for which materials
+		// belong to which script
+		if manifestPath, err = forgeManifest(store, sha, name); err != nil {
+			getFiles.Fail(err)
+			return 1
 		}
-		defer in.Close()
-		io.Copy(hash, in)
+		if materials, err = loadMaterials(recordingReader(forgeReader(store, sha, dir), fetched), manifestPath, name); err != nil {
+			getFiles.Fail(err)
+			return 1
+		}
+	}
+	scriptPrettyName := materials.Artifact.Path
 
-		// Read in the signature file
-		raw, err := os.ReadFile(sigName)
-		if err != nil {
-			fmt.Printf("failed to read sig from %s: %s", "signature.bin", err)
+	// Verify and run the very same file
+	scriptName, err := downloadPath(dir, scriptPrettyName)
+	if err != nil {
+		getFiles.Fail(err)
+		return 1
+	}
+	if local {
+		if err := writeScript(scriptName, materials.Script); err != nil {
+			getFiles.Fail(err)
+			return 1
 		}
+		// Cached materials passed the online checks when they were stored,
+		// they are verified again without the network
+		opts.RekorURL = ""
+	}
+	script, content, err := utils.OpenScript(scriptName)
+	if err != nil {
+		getFiles.Fail(err)
+		return 1
+	}
+	defer script.Close()
+	materials.Script = content
 
-		sigDecode, _ := base64.StdEncoding.DecodeString(string(raw))
+	getFiles.Success()
+	pterm.Info.Println("Resolved " + revision + " to commit " + sha)
+	switch {
+	case b != nil:
+		pterm.Info.Println("Verifying " + scriptPrettyName + " from the bundle offline")
+	case local:
+		pterm.Info.Println("Using cached materials for " + scriptPrettyName)
+	}
 
-		// Marshall out the signature file to asn1
-		sig := &ecdsaSig{}
-		_, err = asn1.Unmarshal(sigDecode, sig)
-		if err != nil {
-			fmt.Printf("invalid signature data")
+	verifySigning, _ := pterm.DefaultSpinner.Start("Performing signing verification  of " + scriptPrettyName)
+	report := verify.Verify(materials, opts)
+	if failure := report.Failure(); failure != nil {
+		verifySigning.Fail(failure.Class, ": ", failure.Name, ": ", failure.Err)
+		return 1
+	}
+	verifySigning.Success()
+	pterm.Info.Println("Script signed by: " + report.Signer.String())
+	pterm.Info.Println("Transparency log entry ", report.Entry.LogIndex, " verified")
+
+	if locked != nil {
+		got := lock.NewScript(loc, scriptPrettyName)
+		got.Commit = sha
+		got.SHA256 = materials.Artifact.SHA256
+		got.Signer = materials.Artifact.Signer
+		got.LogIndex = report.Entry.LogIndex
+		if err := locked.Check(&got); err != nil {
+			pterm.Error.Println(err)
+			return 1
 		}
+		pterm.Info.Println("Matches the lock file")
+	}
 
-		if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
-			fmt.Printf("signature contained zero or negative values")
+	if c != nil && !local {
+		if err := c.Store(&cache.Entry{
+			Repo:       loc.ID(),
+			Owner:      loc.Owner,
+			Name:       loc.Repo,
+			Commit:     sha,
+			Manifests:  map[string]string{scriptPrettyName: manifestPath},
+			Scripts:    []string{scriptPrettyName},
+			VerifiedAt: time.Now().UTC(),
+		}, fetched); err != nil {
+			pterm.Warning.Println("Unable to cache the verified materials: ", err)
 		}
+	}
 
-		// Verify the actual signature signing, if verify fails exit with a failure code
-		if !ecdsa.Verify(ecdsaPublicKey, hash.Sum(nil), sig.R, sig.S) {
-			verifySigning.Fail()
-			os.Exit(1)
-		} else {
-			verifySigning.Success()
+	if record := viper.GetString("record"); record != "" {
+		if err := writeInstallRecord(record, installRecord{
+			Owner:       owner,
+			Repo:        repo,
+			Revision:    revision,
+			Commit:      sha,
+			Script:      scriptPrettyName,
+			SHA256:      materials.Artifact.SHA256,
+			LogIndex:    report.Entry.LogIndex,
+			InstalledAt: time.Now().UTC(),
+		}); err != nil {
+			pterm.Error.Println("unable to record install: ", err)
+			return 1
 		}
+	}
 
-		pterm.Info.Println("sap will now handover to script execution of: " + scriptPrettyName)
+	sandboxPolicy := installSandbox(materials.Artifact.Sandbox)
+	if sandboxPolicy != nil {
+		pterm.Info.Printfln("Sandbox: network %t, writable %v, limits %+v", sandboxPolicy.Network, sandboxPolicy.Writable, sandboxPolicy.Limits)
+	}
+	pterm.Info.Println("sap will now handover to " + report.Interpreter.Name + " execution of: " + scriptPrettyName)
 
-		// Execute the script in question
-		fmt.Println("")
-		err = utils.ExecScript(scriptName)
-		if err != nil {
-			fmt.Printf("error executing: %s %s", scriptName, err)
+	// Execute the script in question with the interpreter the signer declared
+	fmt.Println("")
+	scriptArg := utils.ScriptFD
+	if report.Interpreter.ByPath {
+		scriptArg = scriptName
+	}
+	err = utils.ExecScript(report.Interpreter.Args(scriptArg), utils.ExecOptions{
+		Args:    scriptArgs,
+		Env:     scriptEnv(),
+		Dir:     workdir,
+		Sandbox: sandboxPolicy,
+		Script:  script,
+	})
+	if err != nil {
+		fmt.Printf("error executing: %s %s\n", scriptPrettyName, err)
+		// The script's own exit status is passed on
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
 		}
-		// TODO: clean up by removing all files in temp directory
-	},
+		return 1
+	}
+	return 0
 }
 
 func init() {
 	rootCmd.AddCommand(installCmd)
-	installCmd.PersistentFlags().String("tag", "latest", "The release tag (version)")
+	addRevisionFlags(installCmd.PersistentFlags())
+	addVerifyFlags(installCmd.PersistentFlags())
+	installCmd.PersistentFlags().String("record", "", "Append a JSON record of the installed commit and script to this file")
+	installCmd.PersistentFlags().StringSlice("env-allow", nil, "Environment variables passed to the script, as names or patterns such as LC_* (can be repeated), defaults to all")
+	installCmd.PersistentFlags().StringSlice("env-deny", nil, "Environment variables removed before running the script, as names or patterns (can be repeated)")
+	installCmd.PersistentFlags().String("bundle", "", "Install from a bundle written by sap bundle export, verified offline")
+	installCmd.PersistentFlags().Bool("locked", false, "Only install the commit, content and signer recorded in the lock file")
+	installCmd.PersistentFlags().String("lockfile", lock.FileName, "Lock file used with --locked")
+	installCmd.PersistentFlags().Bool("no-cache", false, "Neither read from nor add to the cache of verified materials")
+	installCmd.PersistentFlags().Bool("keep", false, "Keep the downloaded materials instead of removing them, for debugging")
+	installCmd.PersistentFlags().String("workdir", "", "Working directory of the script, defaults to the current directory")
+	installCmd.PersistentFlags().Bool("sandbox", false, "Run the script in a Linux sandbox with the policy declared by the signer")
+	installCmd.PersistentFlags().Bool("sandbox-no-network", false, "Deny network access even when the signer's policy allows it")
+	addSandboxFlags(installCmd.PersistentFlags())
+}
+
+// scriptEnv is the environment the script runs with. The forge tokens, the
+// variables granting identity tokens and the key password are always removed
+// unless --env-allow names them explicitly.
+func scriptEnv() []string {
+	allow := viper.GetStringSlice("env-allow")
+	deny := viper.GetStringSlice("env-deny")
+	secrets := append([]string{passwordEnv}, idtoken.EnvNames...)
+	for _, token := range forgeTokenEnv {
+		secrets = append(secrets, token)
+	}
+	for _, token := range secrets {
+		explicit := false
+		for _, a := range allow {
+			explicit = explicit || a == token
+		}
+		if !explicit {
+			deny = append(deny, token)
+		}
+	}
+	return utils.FilterEnv(os.Environ(), allow, deny)
+}
+
+// lockedScript finds the named script of repoID in the lock file.
+func lockedScript(repoID, name string) (*lock.Script, error) {
+	file := viper.GetString("lockfile")
+	l, err := lock.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("--locked needs a lock file, run sap lock: %w", err)
+	}
+	s, err := l.Find(repoID, name)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("no script of %s named %q in %s", repoID, name, file)
+	}
+	return s, nil
+}
+
+// installRecord describes the signed revision an install ran, so a deploy
+// can be reproduced from the exact same commit.
+type installRecord struct {
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	Revision    string    `json:"revision"`
+	Commit      string    `json:"commit"`
+	Script      string    `json:"script"`
+	SHA256      string    `json:"sha256"`
+	LogIndex    int64     `json:"logIndex"`
+	InstalledAt time.Time `json:"installedAt"`
+}
+
+// writeInstallRecord appends r as a single JSON line to file.
+func writeInstallRecord(file string, r installRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(b, '\n'))
+	return err
 }
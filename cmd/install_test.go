@@ -0,0 +1,73 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestScriptEnv(t *testing.T) {
+	resetViper(t)
+	secrets := map[string]string{
+		"GITHUB_AUTH_TOKEN":              "ghp_secret",
+		"GITLAB_TOKEN":                   "glpat_secret",
+		"GITEA_TOKEN":                    "gitea_secret",
+		"SAP_PASSWORD":                   "hello",
+		"SIGSTORE_ID_TOKEN":              "eyJ.secret",
+		"ACTIONS_ID_TOKEN_REQUEST_URL":   "https://token.example.com",
+		"ACTIONS_ID_TOKEN_REQUEST_TOKEN": "request_secret",
+	}
+	for name, value := range secrets {
+		t.Setenv(name, value)
+	}
+	t.Setenv("SAP_TEST_PLAIN", "1")
+	t.Setenv("LC_SAP_TEST", "C")
+
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		want  []string
+	}{
+		{name: "defaults", want: []string{"LC_SAP_TEST", "SAP_TEST_PLAIN"}},
+		{name: "allow all", allow: []string{"*"}, want: []string{"LC_SAP_TEST", "SAP_TEST_PLAIN"}},
+		{name: "allow pattern", allow: []string{"*_TOKEN", "SAP_*"}, want: []string{"SAP_TEST_PLAIN"}},
+		{name: "allow by name", allow: []string{"SAP_TEST_PLAIN", "GITEA_TOKEN"}, want: []string{"GITEA_TOKEN", "SAP_TEST_PLAIN"}},
+		{name: "deny pattern", deny: []string{"LC_*"}, want: []string{"SAP_TEST_PLAIN"}},
+		{name: "deny wins", allow: []string{"GITEA_TOKEN", "LC_SAP_TEST"}, deny: []string{"GITEA_*"}, want: []string{"LC_SAP_TEST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("env-allow", tt.allow)
+			viper.Set("env-deny", tt.deny)
+			var got []string
+			for _, kv := range scriptEnv() {
+				name := strings.SplitN(kv, "=", 2)[0]
+				if _, secret := secrets[name]; secret || strings.HasPrefix(name, "SAP_TEST_") || strings.HasPrefix(name, "LC_SAP_TEST") {
+					got = append(got, name)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("scriptEnv kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lukehinds/sap/pkg/keys"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// passwordEnv holds the private key password for unattended signing.
+const passwordEnv = "SAP_PASSWORD"
+
+// generateKeyPairCmd represents the generate-key-pair command
+var generateKeyPairCmd = &cobra.Command{
+	Use:   "generate-key-pair",
+	Short: "Generate a key pair for signing scripts without Fulcio",
+	Long: `Generate a signing key pair, writing the encrypted private key to
+<prefix>.key and the public key to <prefix>.pub. Sign with
+sap sign --key <prefix>.key and configure installers with
+--public-key <prefix>.pub.
+
+The password is read from ` + passwordEnv + ` or prompted for.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefix := viper.GetString("output-key-prefix")
+		keyFile, pubFile := prefix+".key", prefix+".pub"
+		for _, f := range []string{keyFile, pubFile} {
+			if _, err := os.Stat(f); err == nil {
+				return fmt.Errorf("%s already exists", f)
+			}
+		}
+
+		key, err := keys.Generate(viper.GetString("algorithm"))
+		if err != nil {
+			return err
+		}
+		password, err := readPassword(true)
+		if err != nil {
+			return err
+		}
+		keyPEM, err := keys.EncryptPrivateKey(key, password)
+		if err != nil {
+			return err
+		}
+		pubPEM, err := keys.MarshalPublicKey(key.Public())
+		if err != nil {
+			return err
+		}
+		id, err := keys.ID(key.Public())
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return err
+		}
+		if err := os.WriteFile(pubFile, pubPEM, 0644); err != nil {
+			return err
+		}
+		pterm.Success.Println("Private key written to " + keyFile)
+		pterm.Success.Println("Public key written to " + pubFile)
+		pterm.Info.Println("Key fingerprint: " + id)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateKeyPairCmd)
+	generateKeyPairCmd.Flags().String("algorithm", keys.ECDSAP256, "Key algorithm: "+keys.ECDSAP256+" or "+keys.Ed25519)
+	generateKeyPairCmd.Flags().String("output-key-prefix", "sap", "Write the keys to <prefix>.key and <prefix>.pub")
+}
+
+// readPassword returns the private key password from SAP_PASSWORD, or
+// prompts for it on the terminal, twice when confirm is set.
+func readPassword(confirm bool) ([]byte, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		return []byte(password), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no terminal to read the private key password from, set " + passwordEnv)
+	}
+	fmt.Fprint(os.Stderr, "Enter password for private key: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil || !confirm {
+		return password, err
+	}
+	fmt.Fprint(os.Stderr, "Enter password for private key again: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, again) {
+		return nil, errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/lock"
+	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/verify"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock [script...]",
+	Short: "sap lock pins scripts to the commit they were verified at",
+	Long: `Resolve, download and verify the named scripts of the repository and
+record the commit SHA, script sha256, signer identity and Rekor log index of
+each in sap.lock. sap install --locked then refuses anything that deviates.
+
+Scripts that are already locked are only changed with --update, which
+prints what changed. Without scripts, --update resolves every locked script
+again at the revision it was locked from.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := viper.GetString("lockfile")
+		l, err := lock.Load(file)
+		if errors.Is(err, os.ErrNotExist) {
+			l = &lock.Lock{}
+		} else if err != nil {
+			return err
+		}
+		update := viper.GetBool("update")
+
+		type target struct {
+			loc              forge.Location
+			tag, ref, commit string
+			name             string
+			old              *lock.Script
+		}
+		var targets []target
+		if update && len(args) == 0 {
+			for i := range l.Scripts {
+				old := l.Scripts[i]
+				t := target{loc: old.Location(), tag: old.Tag, ref: old.Ref, name: old.Path, old: &old}
+				if t.tag == "" && t.ref == "" {
+					t.commit = old.Commit
+				}
+				targets = append(targets, t)
+			}
+		} else {
+			if viper.GetString("ref") != "" && viper.GetString("commit") != "" {
+				return fmt.Errorf("--ref and --commit can not be used together")
+			}
+			loc, err := forgeLocation()
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				args = []string{""}
+			}
+			for _, name := range args {
+				old, err := l.Find(loc.ID(), name)
+				if err != nil {
+					return err
+				}
+				if old != nil && !update {
+					return fmt.Errorf("%s is already locked, use --update to change it", old)
+				}
+				targets = append(targets, target{
+					loc:    loc,
+					tag:    viper.GetString("tag"),
+					ref:    viper.GetString("ref"),
+					commit: viper.GetString("commit"),
+					name:   name,
+					old:    old,
+				})
+			}
+		}
+
+		for _, t := range targets {
+			s, err := lockScript(t.loc, t.tag, t.ref, t.commit, t.name)
+			if err != nil {
+				return err
+			}
+			switch {
+			case t.old == nil:
+				pterm.Success.Println("Locked " + s.String() + " at commit " + s.Commit)
+			case len(t.old.Diff(s)) == 0:
+				pterm.Info.Println(s.String() + " is unchanged")
+			default:
+				pterm.Warning.Println(s.String() + " changed:")
+				for _, change := range t.old.Diff(s) {
+					fmt.Println("  " + change)
+				}
+			}
+			l.Put(*s)
+		}
+		return l.Write(file)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	addRevisionFlags(lockCmd.Flags())
+	addVerifyFlags(lockCmd.Flags())
+	lockCmd.Flags().String("lockfile", lock.FileName, "Lock file to write")
+	lockCmd.Flags().Bool("update", false, "Resolve locked scripts again and show what changed")
+}
+
+// lockScript resolves and verifies the named script of the repository at
+// loc and returns its lock entry.
+func lockScript(loc forge.Location, tag, ref, commit, name string) (*lock.Script, error) {
+	opts, err := verifyOptions(loc.Owner, loc.Repo, true)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openForge(loc, false)
+	if err != nil {
+		return nil, err
+	}
+	sha, err := f.ResolveCommit(ctx, tag, ref, commit)
+	if err != nil {
+		return nil, err
+	}
+	manifestPath, err := forgeManifest(f, sha, name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := utils.TempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	materials, err := loadMaterials(forgeReader(f, sha, dir), manifestPath, name)
+	if err != nil {
+		return nil, err
+	}
+	report := verify.Verify(materials, opts)
+	if failure := report.Failure(); failure != nil {
+		return nil, fmt.Errorf("%s: %s: %s: %w", materials.Artifact.Path, failure.Class, failure.Name, failure.Err)
+	}
+
+	s := lock.NewScript(loc, materials.Artifact.Path)
+	switch {
+	case commit != "":
+	case ref != "":
+		s.Ref = ref
+	default:
+		s.Tag = tag
+	}
+	s.Commit = sha
+	s.SHA256 = materials.Artifact.SHA256
+	s.Signer = materials.Artifact.Signer
+	s.LogIndex = report.Entry.LogIndex
+	return &s, nil
+}
@@ -0,0 +1,278 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/verify"
+)
+
+// materialReader reads a file of the signed materials by repository path.
+// Readers that fetch files check them against sha256 when it is not empty.
+type materialReader func(repoPath string, sha256 string) ([]byte, error)
+
+// forgeReader reads the materials as of commit sha from the forge,
+// downloading them into dir under their repository path.
+func forgeReader(f forge.Forge, sha string, dir string) materialReader {
+	return func(repoPath string, sha256 string) ([]byte, error) {
+		localPath, err := downloadPath(dir, repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
+			return nil, err
+		}
+		if err := f.Download(ctx, sha, repoPath, sha256, localPath); err != nil {
+			return nil, err
+		}
+		return utils.ReadFile(localPath)
+	}
+}
+
+// downloadPath is where forgeReader stores repoPath below dir. The manifest
+// is not verified yet when its paths are used, so they may not leave dir.
+func downloadPath(dir, repoPath string) (string, error) {
+	clean := path.Clean(repoPath)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%s: materials must be inside the repository", repoPath)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// checkoutReader reads the materials from a local checkout at dir. Digests
+// are left to verification, which reports them as such.
+func checkoutReader(dir string) materialReader {
+	return func(repoPath string, _ string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(repoPath)))
+	}
+}
+
+// forgeManifest finds the manifest of the named script as of commit sha. A
+// script named by path is looked up where the layout puts its manifest,
+// otherwise the manifest is searched in the tree of the commit below the
+// layout and legacy roots, so any commit holding the materials works and
+// not only the one that added them.
+func forgeManifest(f forge.Forge, sha string, name string) (string, error) {
+	dir, err := utils.TempDir()
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if name != "" {
+		manifestPath := manifest.Path(name)
+		if err := f.Download(ctx, sha, manifestPath, "", filepath.Join(dir, "layout", manifest.FileName)); err == nil {
+			return manifestPath, nil
+		}
+	}
+	var manifests []string
+	for _, root := range []string{manifest.Root, manifest.LegacyRoot} {
+		files, err := f.ListFiles(ctx, sha, root)
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			if path.Base(file) == manifest.FileName {
+				manifests = append(manifests, file)
+			}
+		}
+	}
+	return findManifest(manifests, forgeReader(f, sha, filepath.Join(dir, "tree")), name, "commit "+sha)
+}
+
+// findManifest picks the manifest of the named script among manifests found
+// in where. Manifests in the layout are matched by the script path they
+// belong to, or its file name when unambiguous. Manifests written before the
+// layout may cover several scripts, the one that counts is the one that
+// signed the content of the script read next to it. Without a name there
+// must be a single manifest.
+func findManifest(manifests []string, read materialReader, name string, where string) (string, error) {
+	if len(manifests) == 0 {
+		return "", fmt.Errorf("no sap manifest found in %s", where)
+	}
+	var scripts, matches, legacy []string
+	for _, manifestPath := range manifests {
+		script, ok := manifest.ScriptPath(manifestPath)
+		if !ok {
+			// Manifests written before the layout are listed by their path
+			script = manifestPath
+			legacy = append(legacy, manifestPath)
+		}
+		scripts = append(scripts, script)
+		if ok && name != "" && (script == path.Clean(name) || path.Base(script) == name) {
+			matches = append(matches, manifestPath)
+		}
+	}
+	switch {
+	case name == "" && len(manifests) == 1:
+		return manifests[0], nil
+	case name == "":
+		return "", fmt.Errorf("%s signs %d scripts, name the one to use: %s", where, len(manifests), strings.Join(scripts, ", "))
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return "", fmt.Errorf("%s is ambiguous in %s, use the full path", name, where)
+	}
+
+	// A script may be read once only, several manifests can cover it
+	digests := map[string]string{}
+	for _, manifestPath := range legacy {
+		b, err := read(manifestPath, "")
+		if err != nil {
+			return "", err
+		}
+		m, err := manifest.Parse(b)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", manifestPath, err)
+		}
+		artifact, err := selectArtifact(m, name)
+		if err != nil {
+			continue
+		}
+		digest, ok := digests[artifact.Path]
+		if !ok {
+			script, err := read(artifact.Path, "")
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(script)
+			digest = hex.EncodeToString(sum[:])
+			digests[artifact.Path] = digest
+		}
+		if digest == artifact.SHA256 {
+			return manifestPath, nil
+		}
+	}
+	return "", fmt.Errorf("no manifest in %s signs the content of %s there", where, name)
+}
+
+// checkoutManifest finds the manifest in a local checkout that signed the
+// current content of the named script. Without a name the checkout must
+// hold a single manifest.
+func checkoutManifest(dir string, name string) (string, error) {
+	if name != "" {
+		manifestPath := manifest.Path(name)
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(manifestPath))); err == nil {
+			return manifestPath, nil
+		}
+	}
+	var manifests []string
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == manifest.FileName {
+			rel, err := filepath.Rel(dir, file)
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return findManifest(manifests, checkoutReader(dir), name, dir)
+}
+
+// loadMaterials reads the manifest at manifestPath and everything needed to
+// verify the script picked by name.
+func loadMaterials(read materialReader, manifestPath string, name string) (*verify.Materials, error) {
+	m := &verify.Materials{}
+	var err error
+	if m.Manifest, err = read(manifestPath, ""); err != nil {
+		return nil, err
+	}
+	if m.ManifestSignature, err = read(path.Join(path.Dir(manifestPath), manifest.SignatureFileName), ""); err != nil {
+		return nil, err
+	}
+	parsed, err := manifest.Parse(m.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	if m.Artifact, err = selectArtifact(parsed, name); err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		repoPath string
+		sha256   string
+		dest     *[]byte
+	}{
+		{m.Artifact.Path, m.Artifact.SHA256, &m.Script},
+		{m.Artifact.Certificate, "", &m.Certificate},
+		{m.Artifact.Signature, "", &m.Signature},
+		{m.Artifact.Rekor.Entry, "", &m.Entry},
+		{m.Artifact.Chain, "", &m.Chain},
+	}
+	for _, f := range files {
+		if f.repoPath == "" {
+			continue
+		}
+		if *f.dest, err = read(f.repoPath, f.sha256); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// selectArtifact picks the script from the manifest, by path or, when
+// unambiguous, by file name. Without a name the manifest must contain a
+// single script.
+func selectArtifact(m *manifest.Manifest, name string) (manifest.Artifact, error) {
+	var paths []string
+	for _, a := range m.Artifacts {
+		paths = append(paths, a.Path)
+	}
+	if name == "" {
+		if len(m.Artifacts) != 1 {
+			return manifest.Artifact{}, fmt.Errorf("release contains %d scripts, name the one to use: %s",
+				len(m.Artifacts), strings.Join(paths, ", "))
+		}
+		return m.Artifacts[0], nil
+	}
+	if a := m.Find(path.Clean(name)); a != nil {
+		return *a, nil
+	}
+	var matches []manifest.Artifact
+	for _, a := range m.Artifacts {
+		if path.Base(a.Path) == name {
+			matches = append(matches, a)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return manifest.Artifact{}, fmt.Errorf("no script named %s in the release, available scripts: %s",
+			name, strings.Join(paths, ", "))
+	case 1:
+		return matches[0], nil
+	}
+	return manifest.Artifact{}, fmt.Errorf("%s matches more than one script, use the full path", name)
+}
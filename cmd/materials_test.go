@@ -0,0 +1,101 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/manifest"
+)
+
+func TestFindManifest(t *testing.T) {
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	legacyManifest := func(artifacts ...manifest.Artifact) []byte {
+		b, err := manifest.Marshal(&manifest.Manifest{SchemaVersion: 1, Artifacts: artifacts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	stale := path.Join(manifest.LegacyRoot, "run1", manifest.FileName)
+	current := path.Join(manifest.LegacyRoot, "run2", manifest.FileName)
+	files := map[string][]byte{
+		"old.sh": []byte("echo new\n"),
+		// run1 signed an earlier version of old.sh, run2 the current one
+		stale:   legacyManifest(manifest.Artifact{Path: "old.sh", SHA256: digest("echo old\n")}),
+		current: legacyManifest(manifest.Artifact{Path: "old.sh", SHA256: digest("echo new\n")}, manifest.Artifact{Path: "other.sh", SHA256: digest("echo other\n")}),
+	}
+	layout := []string{manifest.Path("install.sh"), manifest.Path("tools/setup.sh"), manifest.Path("ci/setup.sh")}
+	all := append(append([]string{}, layout...), stale, current)
+
+	tests := []struct {
+		name      string
+		manifests []string
+		script    string
+		want      string
+		err       bool
+	}{
+		{name: "layout path", manifests: all, script: "tools/setup.sh", want: manifest.Path("tools/setup.sh")},
+		{name: "layout unclean path", manifests: all, script: "./tools//setup.sh", want: manifest.Path("tools/setup.sh")},
+		{name: "layout file name", manifests: all, script: "install.sh", want: manifest.Path("install.sh")},
+		{name: "ambiguous file name", manifests: all, script: "setup.sh", err: true},
+		{name: "no name, several manifests", manifests: all, err: true},
+		{name: "no name, single manifest", manifests: layout[:1], want: manifest.Path("install.sh")},
+		{name: "no name, single legacy manifest", manifests: []string{current}, want: current},
+		{name: "legacy signing the current content", manifests: all, script: "old.sh", want: current},
+		{name: "legacy signing other content only", manifests: []string{stale}, script: "old.sh", err: true},
+		{name: "legacy script that is gone", manifests: all, script: "other.sh", err: true},
+		{name: "unknown script", manifests: all, script: "missing.sh", err: true},
+		{name: "no manifests", script: "install.sh", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reads := map[string]int{}
+			read := func(repoPath string, sha256 string) ([]byte, error) {
+				reads[repoPath]++
+				content, ok := files[repoPath]
+				if !ok {
+					return nil, fmt.Errorf("%s not found", repoPath)
+				}
+				return content, nil
+			}
+			got, err := findManifest(tt.manifests, read, tt.script, "commit")
+			if tt.err {
+				if err == nil {
+					t.Errorf("findManifest = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("findManifest = %s, want %s", got, tt.want)
+			}
+			// A script covered by several manifests is read once
+			if reads["old.sh"] > 1 {
+				t.Errorf("old.sh was read %d times", reads["old.sh"])
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// publishCmd represents the publish command
+var publishCmd = &cobra.Command{
+	Use:   "publish <dir>",
+	Short: "Commit signed materials written by sap sign --no-push",
+	Long: `Commit the materials sap sign --no-push wrote below <dir>, usually its
+--output-dir, together with the signed scripts, and open a pull request for
+them.
+
+The directory holding .sap stands for the top of the repository, so
+materials written with sap sign --output-dir can be copied to another machine
+and published there. Manifests in .sigstore, written before the .sap layout,
+are skipped with a warning.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := publishedFiles(args[0])
+		if err != nil {
+			return err
+		}
+		store, err := newForge(true)
+		if err != nil {
+			return err
+		}
+		return publishMaterials(store, files)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(publishCmd)
+	addPublishFlags(publishCmd.Flags())
+}
+
+// addPublishFlags adds the flags describing the commit and pull request of
+// the signed materials.
+func addPublishFlags(flags *pflag.FlagSet) {
+	flags.String("author-email", "sign@sigstore.dev", "Used for the Author email")
+	flags.String("author-name", "sigstore", "Used for the Author Name, default is \"sigstore\"")
+	flags.String("base-branch", "main", "Name of branch to create the commit-branch from. (default \"main\")")
+	flags.String("commit-branch", "", "Name of branch to create the commit in. If it does not already exists, it will be created using the base-branch parameter")
+	flags.String("commit-message", "", "Content of the commit message.")
+	flags.String("merge-branch", "main", "Name of branch to create the PR against (the one you want to merge your branch in via the PR). (default \"main\")")
+	flags.String("merge-repo", "", "Name of repo to create the PR against. If not specified, the value of the --repo flag will be used.")
+	flags.String("merge-repo-owner", "", "Name of the owner (user or org) of the repo to create the PR against. If not specified, the value of the --owner flag will be used.\"")
+	flags.String("pr-text", "", "Text to put in the description of the pull request")
+	flags.String("pr-title", "", " Title of the pull request. If not specified, no pull request will be created")
+}
+
+// publishMaterials commits files, given as forge.Commit file entries, and
+// opens the pull request for them.
+func publishMaterials(store forge.Forge, files []string) error {
+	sha, err := store.CommitFiles(ctx, forge.Commit{
+		Branch:      viper.GetString("commit-branch"),
+		BaseBranch:  viper.GetString("base-branch"),
+		AuthorName:  viper.GetString("author-name"),
+		AuthorEmail: viper.GetString("author-email"),
+		Message:     viper.GetString("commit-message"),
+		Files:       files,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the commit: %s", err)
+	}
+	fmt.Println("Signed materials committed as", sha)
+
+	if err := store.CreateMergeRequest(ctx, forge.MergeRequest{
+		Owner:  viper.GetString("merge-repo-owner"),
+		Repo:   viper.GetString("merge-repo"),
+		Branch: viper.GetString("commit-branch"),
+		Base:   viper.GetString("merge-branch"),
+		Title:  viper.GetString("pr-title"),
+		Body:   viper.GetString("pr-text"),
+	}); err != nil {
+		return errors.New(fmt.Sprintf("error while creating the pull request: %s", err))
+	}
+	return nil
+}
+
+// publishedFiles finds the manifests below dir and returns the forge.Commit
+// file entries of each manifest, its signature, the script it covers and its
+// materials. Each script must still match the digest it was signed with.
+// Legacy manifests below manifest.LegacyRoot are skipped with a warning.
+func publishedFiles(dir string) ([]string, error) {
+	var manifests []string
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		// Manifests written before the layout may cover several scripts,
+		// they stay as they are in the repository
+		if d.IsDir() && d.Name() == manifest.LegacyRoot {
+			pterm.Warning.Println("Not publishing " + file + ", it was written before the layout: sign its scripts again to publish them")
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == manifest.FileName {
+			manifests = append(manifests, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no signed materials found in %s", dir)
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, manifestFile := range manifests {
+		m, err := manifest.Read(manifestFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", manifestFile, err)
+		}
+		if len(m.Artifacts) != 1 {
+			return nil, fmt.Errorf("%s covers %d scripts, sign them again to publish them", manifestFile, len(m.Artifacts))
+		}
+		a := m.Artifacts[0]
+
+		// The manifest of a script sits at a fixed path relative to the top
+		// of the repository, which is what is left of its local path without
+		// it
+		repoPath := manifest.Path(a.Path)
+		local := filepath.ToSlash(filepath.Clean(manifestFile))
+		if local != repoPath && !strings.HasSuffix(local, "/"+repoPath) {
+			return nil, fmt.Errorf("%s is not at %s, where the manifest of %s belongs", manifestFile, repoPath, a.Path)
+		}
+		root := filepath.Clean(filepath.FromSlash(strings.TrimSuffix(local, repoPath)))
+
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(a.Path)))
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(b)
+		if hex.EncodeToString(digest[:]) != a.SHA256 {
+			return nil, fmt.Errorf("%s changed since it was signed", filepath.Join(root, a.Path))
+		}
+
+		for _, p := range []string{
+			a.Path, repoPath, path.Join(manifest.Dir(a.Path), manifest.SignatureFileName),
+			a.Signature, a.Certificate, a.Chain, a.PublicKey, a.Rekor.Entry,
+		} {
+			if p == "" || seen[p] {
+				continue
+			}
+			if clean := path.Clean(p); path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+				return nil, fmt.Errorf("%s refers to %s outside of the repository", manifestFile, p)
+			}
+			if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(p))); err != nil {
+				return nil, err
+			}
+			seen[p] = true
+			files = append(files, materialFile(root, p))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,110 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/manifest"
+)
+
+// writeSigned writes scripts below root, each with its materials, and a
+// manifest at manifestPath covering them.
+func writeSigned(t *testing.T, root string, manifestPath string, scripts ...string) {
+	t.Helper()
+	m := &manifest.Manifest{SchemaVersion: 1}
+	for _, script := range scripts {
+		content := []byte("echo " + script + "\n")
+		digest := sha256.Sum256(content)
+		dir := manifest.Dir(script)
+		a := manifest.Artifact{
+			Path:      script,
+			SHA256:    hex.EncodeToString(digest[:]),
+			Signature: path.Join(dir, manifest.SignatureName),
+			PublicKey: path.Join(dir, manifest.PublicKeyName),
+			Rekor:     manifest.Rekor{Entry: path.Join(dir, manifest.RekorName)},
+		}
+		m.Artifacts = append(m.Artifacts, a)
+		for p, b := range map[string][]byte{
+			script:        content,
+			a.Signature:   []byte("sig"),
+			a.PublicKey:   []byte("key"),
+			a.Rekor.Entry: []byte("{}"),
+			path.Join(dir, manifest.SignatureFileName): []byte("sig"),
+		} {
+			file := filepath.Join(root, filepath.FromSlash(p))
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, file, b)
+		}
+	}
+	file := filepath.Join(root, filepath.FromSlash(manifestPath))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(file, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishedFiles(t *testing.T) {
+	root := t.TempDir()
+	writeSigned(t, root, manifest.Path("tools/setup.sh"), "tools/setup.sh")
+	// A legacy manifest covering several scripts is left alone
+	writeSigned(t, root, path.Join(manifest.LegacyRoot, "run1", manifest.FileName), "a.sh", "b.sh")
+
+	files, err := publishedFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published []string
+	for _, f := range files {
+		published = append(published, f[strings.LastIndex(f, ":")+1:])
+	}
+	sort.Strings(published)
+	want := []string{
+		".sap/tools/setup.sh/key.pub",
+		".sap/tools/setup.sh/rekor.json",
+		".sap/tools/setup.sh/sap-manifest.json",
+		".sap/tools/setup.sh/sap-manifest.sig",
+		".sap/tools/setup.sh/sig",
+		"tools/setup.sh",
+	}
+	if strings.Join(published, " ") != strings.Join(want, " ") {
+		t.Errorf("publishedFiles = %q, want %q", published, want)
+	}
+
+	// Only legacy manifests leave nothing to publish
+	legacy := t.TempDir()
+	writeSigned(t, legacy, path.Join(manifest.LegacyRoot, "run1", manifest.FileName), "a.sh", "b.sh")
+	if _, err := publishedFiles(legacy); err == nil {
+		t.Error("publishedFiles of legacy manifests only succeeded")
+	}
+
+	// Scripts changed since signing are refused
+	writeFile(t, filepath.Join(root, "tools", "setup.sh"), []byte("echo changed\n"))
+	if _, err := publishedFiles(root); err == nil || !strings.Contains(err.Error(), "changed since it was signed") {
+		t.Errorf("publishedFiles of a changed script = %v", err)
+	}
+}
@@ -0,0 +1,287 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lukehinds/sap/pkg/bundle"
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/verify"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// releasePollInterval is how often release checks whether the pull request
+// was merged while waiting.
+const releasePollInterval = 30 * time.Second
+
+// releaseCmd represents the release command
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Tag and release signed scripts once their pull request is merged",
+	Long: `Find the commit that merged the pull request of --commit-branch into
+--merge-branch, waiting up to --wait for the merge, and tag it with --tag.
+The release created for the tag lists the digest of the manifest and the
+Rekor log index of every script signed as of that commit, and has a bundle
+of each script attached for offline installs with sap install --bundle.
+Every bundle is verified like sap install --bundle would before anything is
+released, so configure the same trust as for sap verify.
+
+Run it against the repository the pull request was merged into, and set
+--head-owner when the pull request came from a fork.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		tag := viper.GetString("tag")
+		if tag == "" {
+			return errors.New("--tag is required")
+		}
+		branch := viper.GetString("commit-branch")
+		if branch == "" {
+			return errors.New("--commit-branch is required")
+		}
+		loc, err := forgeLocation()
+		if err != nil {
+			return err
+		}
+		store, err := openForge(loc, true)
+		if err != nil {
+			return err
+		}
+		opts, err := verifyOptions(loc.Owner, loc.Repo, !viper.GetBool("offline"))
+		if err != nil {
+			return err
+		}
+
+		mr := forge.MergeRequest{
+			HeadOwner: viper.GetString("head-owner"),
+			Branch:    branch,
+			Base:      viper.GetString("merge-branch"),
+		}
+		sha, err := mergedCommit(store, mr, viper.GetDuration("wait"))
+		if err != nil {
+			return err
+		}
+		pterm.Info.Println(branch + " was merged into " + mr.Base + " as " + sha)
+
+		// Every signed script has its own manifest in the layout
+		manifestPaths, err := layoutManifests(store, sha)
+		if err != nil {
+			return err
+		}
+		if len(manifestPaths) == 0 {
+			return fmt.Errorf("no sap manifest found in commit %s", sha)
+		}
+		dir, err := utils.TempDir()
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		read := forgeReader(store, sha, dir)
+		var manifests []releasedManifest
+		for _, manifestPath := range manifestPaths {
+			content, err := read(manifestPath, "")
+			if err != nil {
+				return err
+			}
+			m, err := manifest.Parse(content)
+			if err != nil {
+				return fmt.Errorf("%s: %w", manifestPath, err)
+			}
+			manifests = append(manifests, releasedManifest{path: manifestPath, content: content, manifest: m})
+		}
+
+		bundles, err := releaseBundles(store, loc, sha, manifests, opts)
+		if err != nil {
+			return err
+		}
+		names, err := bundleNames(bundles)
+		if err != nil {
+			return err
+		}
+		bundleDir := viper.GetString("bundle-dir")
+		if bundleDir == "" {
+			if bundleDir, err = utils.TempDir(); err != nil {
+				return err
+			}
+			defer os.RemoveAll(bundleDir)
+		} else if err := os.MkdirAll(bundleDir, 0755); err != nil {
+			return err
+		}
+		var assets []string
+		for i, b := range bundles {
+			file := filepath.Join(bundleDir, names[i])
+			if err := b.Write(file); err != nil {
+				return err
+			}
+			assets = append(assets, file)
+		}
+
+		name := viper.GetString("release-name")
+		if name == "" {
+			name = tag
+		}
+		url, err := store.CreateRelease(ctx, forge.Release{
+			Tag:    tag,
+			Commit: sha,
+			Name:   name,
+			Body:   releaseBody(tag, manifests),
+			Assets: assets,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create the release: %w", err)
+		}
+		pterm.Success.Println("Released " + tag + " at commit " + sha)
+		if url != "" {
+			pterm.Info.Println(url)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+	releaseCmd.Flags().String("tag", "", "Tag to create on the merge commit")
+	releaseCmd.Flags().String("release-name", "", "Name of the release, defaults to the tag")
+	releaseCmd.Flags().String("commit-branch", "", "Branch the signed materials were committed to")
+	releaseCmd.Flags().String("head-owner", "", "Owner of the fork --commit-branch was pushed to, when the pull request came from a fork")
+	releaseCmd.Flags().String("merge-branch", "main", "Branch the pull request was opened against")
+	releaseCmd.Flags().String("bundle-dir", "", "Also keep the bundles attached to the release in this directory")
+	releaseCmd.Flags().Duration("wait", 0, "How long to wait for the pull request to be merged")
+	releaseCmd.Flags().Bool("offline", false, "Do not look the entries up on the Rekor server, rely on the committed inclusion proofs")
+	addVerifyFlags(releaseCmd.Flags())
+}
+
+// mergedCommit returns the commit that merged mr, checking again every
+// releasePollInterval until wait has passed.
+func mergedCommit(store forge.Forge, mr forge.MergeRequest, wait time.Duration) (string, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		sha, err := store.MergedCommit(ctx, mr)
+		if err != nil {
+			return "", err
+		}
+		if sha != "" {
+			return sha, nil
+		}
+		if time.Now().Add(releasePollInterval).After(deadline) {
+			return "", fmt.Errorf("%s is not merged into %s yet", mr.Branch, mr.Base)
+		}
+		pterm.Info.Println("Waiting for " + mr.Branch + " to be merged into " + mr.Base)
+		time.Sleep(releasePollInterval)
+	}
+}
+
+// layoutManifests lists the manifests in the layout as of commit sha.
+func layoutManifests(store forge.Forge, sha string) ([]string, error) {
+	files, err := store.ListFiles(ctx, sha, manifest.Root)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []string
+	for _, file := range files {
+		if _, ok := manifest.ScriptPath(file); ok {
+			manifests = append(manifests, file)
+		}
+	}
+	return manifests, nil
+}
+
+// releasedManifest is a manifest of the released commit.
+type releasedManifest struct {
+	path     string
+	content  []byte
+	manifest *manifest.Manifest
+}
+
+// releaseBundles reads a bundle of each released script and verifies it as
+// sap install --bundle would, failing on the first script that does not
+// verify.
+func releaseBundles(store forge.Forge, loc forge.Location, sha string, manifests []releasedManifest, opts verify.Options) ([]*bundle.Bundle, error) {
+	var bundles []*bundle.Bundle
+	for _, rm := range manifests {
+		for _, a := range rm.manifest.Artifacts {
+			b, err := forgeBundle(store, loc, sha, rm.path, a.Path)
+			if err != nil {
+				return nil, err
+			}
+			materials, err := loadMaterials(bundleReader(b), b.Manifest, b.Script)
+			if err != nil {
+				return nil, err
+			}
+			report := verify.Verify(materials, opts)
+			printReport(b.Script, report)
+			if failure := report.Failure(); failure != nil {
+				return nil, fmt.Errorf("%s does not verify, not releasing it: %w", b.Script, failure.Err)
+			}
+			bundles = append(bundles, b)
+		}
+	}
+	return bundles, nil
+}
+
+// bundleNames names the bundle of each script after the script, or when
+// scripts in different directories share a name, after the script and a
+// digest of its path. Two bundles are never given the same name.
+func bundleNames(bundles []*bundle.Bundle) ([]string, error) {
+	count := map[string]int{}
+	for _, b := range bundles {
+		count[path.Base(b.Script)]++
+	}
+	names := make([]string, len(bundles))
+	seen := map[string]string{}
+	for i, b := range bundles {
+		name := path.Base(b.Script)
+		if count[name] > 1 {
+			digest := sha256.Sum256([]byte(b.Script))
+			name += "-" + hex.EncodeToString(digest[:6])
+		}
+		name += ".sapbundle"
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("the bundles of %s and %s would both be named %s", other, b.Script, name)
+		}
+		seen[name] = b.Script
+		names[i] = name
+	}
+	return names, nil
+}
+
+// releaseBody describes the signed scripts of the release.
+func releaseBody(tag string, manifests []releasedManifest) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Scripts signed with sap.\n\n")
+	fmt.Fprintf(&body, "| Script | sha256 | Manifest sha256 | Rekor log index |\n")
+	fmt.Fprintf(&body, "| --- | --- | --- | --- |\n")
+	for _, rm := range manifests {
+		digest := sha256.Sum256(rm.content)
+		for _, a := range rm.manifest.Artifacts {
+			fmt.Fprintf(&body, "| `%s` | `%s` | `%s` | %d |\n", a.Path, a.SHA256, hex.EncodeToString(digest[:]), a.Rekor.LogIndex)
+		}
+	}
+	fmt.Fprintf(&body, "\nInstall with `sap install <script> --tag %s`, or offline from the attached bundles with `sap install --bundle <bundle>`.\n", tag)
+	return body.String()
+}
@@ -0,0 +1,79 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/bundle"
+)
+
+func TestBundleNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		scripts []string
+		want    []string
+		err     bool
+	}{
+		{
+			name:    "unique names",
+			scripts: []string{"install.sh", "tools/setup.py"},
+			want:    []string{"install.sh.sapbundle", "setup.py.sapbundle"},
+		},
+		{
+			// a_b/c.sh and a/b_c.sh both flatten to a_b_c.sh
+			name:    "shared names",
+			scripts: []string{"a_b/c.sh", "a/b_c.sh", "a/b/c.sh"},
+		},
+		{
+			name:    "same script twice",
+			scripts: []string{"install.sh", "install.sh"},
+			err:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bundles []*bundle.Bundle
+			for _, s := range tt.scripts {
+				bundles = append(bundles, &bundle.Bundle{Script: s})
+			}
+			got, err := bundleNames(bundles)
+			if tt.err {
+				if err == nil {
+					t.Errorf("bundleNames(%q) = %q, want an error", tt.scripts, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := map[string]bool{}
+			for i, name := range got {
+				if tt.want != nil && name != tt.want[i] {
+					t.Errorf("bundle of %s is named %s, want %s", tt.scripts[i], name, tt.want[i])
+				}
+				if !strings.HasSuffix(name, ".sapbundle") || strings.Contains(name, "/") {
+					t.Errorf("bundle of %s is named %s", tt.scripts[i], name)
+				}
+				if seen[name] {
+					t.Errorf("two bundles are named %s", name)
+				}
+				seen[name] = true
+			}
+		})
+	}
+}
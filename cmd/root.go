@@ -18,9 +18,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/lukehinds/sap/pkg/download"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
@@ -39,6 +41,20 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Several commands share flag names, bind the ones of the command
+		// that is actually running
+		if err := viper.BindPFlags(cmd.Flags()); err != nil {
+			return err
+		}
+		if err := applyProfile(cmd); err != nil {
+			return err
+		}
+		download.DefaultLimits.MaxSize = viper.GetInt64("download-max-mb") << 20
+		download.DefaultLimits.Timeout = viper.GetDuration("download-timeout")
+		download.DefaultLimits.Retries = viper.GetInt("download-retries")
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -53,8 +69,20 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.sap.yaml)")
+	rootCmd.PersistentFlags().String("profile", "", "Named profile from the profiles section of the config file")
 	rootCmd.PersistentFlags().StringVar(&owner, "owner", "", "The owner (username or organization containing the repo")
-	rootCmd.PersistentFlags().StringVar(&repo, "repo", "", "The GitHub repository")
+	rootCmd.PersistentFlags().StringVar(&repo, "repo", "", "The repository name")
+	rootCmd.PersistentFlags().String("forge", "github", "Git forge hosting the repository: github, gitlab, gitea or local")
+	rootCmd.PersistentFlags().String("forge-url", "", "Base URL of a self hosted GitLab or Gitea instance")
+	rootCmd.PersistentFlags().String("github-url", "", "API base URL of a GitHub Enterprise Server, for example https://github.example.com")
+	rootCmd.PersistentFlags().String("github-upload-url", "", "Upload URL of a GitHub Enterprise Server, defaults to the --github-url host")
+	rootCmd.PersistentFlags().String("repo-url", "", "Repository URL, the scheme selects the forge (github://, gitlab://, gitea://, file://)")
+	rootCmd.PersistentFlags().String("local-repo", "", "Path to a local or bare git repository to use instead of a forge")
+	rootCmd.PersistentFlags().StringVar(&rekorAddr, "rekor-server", "https://rekor.sigstore.dev", "address of rekor STL server")
+	rootCmd.PersistentFlags().Int64("download-max-mb", 10, "Largest file sap downloads, in MiB")
+	rootCmd.PersistentFlags().Duration("download-timeout", 30*time.Second, "Timeout of each download attempt")
+	rootCmd.PersistentFlags().Int("download-retries", 3, "Number of times a failed download is retried, with backoff")
+	rootCmd.PersistentFlags().String("cache-dir", "", "Cache of verified materials (default is $XDG_CACHE_HOME/sap)")
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
 		fmt.Println(err)
@@ -87,3 +115,24 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// applyProfile overlays the settings of the profile selected with --profile,
+// such as github-url, fulcio-server, rekor-server or oidc-issuer, on top of
+// the config file. Flags given on the command line still take precedence.
+func applyProfile(cmd *cobra.Command) error {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil
+	}
+	settings := viper.GetStringMap("profiles." + name)
+	if len(settings) == 0 {
+		return fmt.Errorf("profile %s not found in %s", name, viper.ConfigFileUsed())
+	}
+	for key, value := range settings {
+		if f := cmd.Flags().Lookup(key); f != nil && f.Changed {
+			continue
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
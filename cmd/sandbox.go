@@ -0,0 +1,80 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/lukehinds/sap/pkg/sandbox"
+	"github.com/pterm/pterm"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addSandboxFlags adds the flags describing writable paths and resource
+// limits of a sandbox.
+func addSandboxFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("sandbox-writable", nil, "Absolute path the sandboxed script may write to (can be repeated)")
+	flags.Uint64("sandbox-cpu-seconds", 0, "CPU time limit of the sandboxed script")
+	flags.Uint64("sandbox-memory-mb", 0, "Address space limit of the sandboxed script in MiB")
+	flags.Uint64("sandbox-processes", 0, "Limit on the processes of the sandboxed script")
+	flags.Uint64("sandbox-file-size-mb", 0, "Largest file the sandboxed script may write in MiB")
+}
+
+// sandboxLimits reads the resource limits set with addSandboxFlags.
+func sandboxLimits() sandbox.Limits {
+	return sandbox.Limits{
+		CPUSeconds: viper.GetUint64("sandbox-cpu-seconds"),
+		MemoryMB:   viper.GetUint64("sandbox-memory-mb"),
+		Processes:  viper.GetUint64("sandbox-processes"),
+		FileSizeMB: viper.GetUint64("sandbox-file-size-mb"),
+	}
+}
+
+// declaredSandbox is the policy sign records for the scripts, or nil when
+// --sandbox was not given.
+func declaredSandbox() (*sandbox.Policy, error) {
+	if !viper.GetBool("sandbox") {
+		return nil, nil
+	}
+	p := &sandbox.Policy{
+		Network:  viper.GetBool("sandbox-network"),
+		Writable: viper.GetStringSlice("sandbox-writable"),
+		Limits:   sandboxLimits(),
+	}
+	return p, p.Validate()
+}
+
+// installSandbox is the policy install runs a script with: the one the
+// signer declared, narrowed by the installer's flags. Declared writable
+// paths are only kept when --sandbox-writable allows them. It is nil when
+// --sandbox was not given.
+func installSandbox(declared *sandbox.Policy) *sandbox.Policy {
+	if !viper.GetBool("sandbox") {
+		return nil
+	}
+	var p sandbox.Policy
+	if declared != nil {
+		p = *declared
+	}
+	p = p.Restrict(sandbox.Restrictions{
+		NoNetwork: viper.GetBool("sandbox-no-network"),
+		Writable:  viper.GetStringSlice("sandbox-writable"),
+		Limits:    sandboxLimits(),
+	})
+	if declared != nil && len(p.Writable) < len(declared.Writable) {
+		pterm.Warning.Printfln("The signer declared writable paths %v, only %v are allowed; use --sandbox-writable to allow more", declared.Writable, p.Writable)
+	}
+	return &p
+}
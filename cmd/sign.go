@@ -17,25 +17,32 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
-	"strconv"
-	"time"
+	"path"
+	"path/filepath"
+	"strings"
 
-	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/go-github/v35/github"
-	"github.com/lukehinds/sap/pkg/githubapi"
-	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/idtoken"
+	"github.com/lukehinds/sap/pkg/interpreter"
+	"github.com/lukehinds/sap/pkg/keys"
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/lukehinds/sap/pkg/rekor"
+	"github.com/lukehinds/sap/pkg/verify"
 	"github.com/sigstore/sigstore/pkg/generated/client/operations"
 	"github.com/sigstore/sigstore/pkg/httpclients"
 	"github.com/sigstore/sigstore/pkg/oauthflow"
 	"github.com/sigstore/sigstore/pkg/signature"
-	"github.com/sigstore/sigstore/pkg/tlog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
@@ -48,226 +55,490 @@ var (
 	ctx        = context.Background()
 )
 
-var supportedFileTypes = map[string]struct{}{
-	"text/plain; charset=utf-8": {},
-}
-
 // signCmd represents the sign command
 var signCmd = &cobra.Command{
-	Use:   "sign",
+	Use:   "sign [scripts...]",
 	Short: "Sign a script using sap",
-	Long:  `Sign a script using sap and store within a GitHub repository.`,
+	Long:  `Sign a script using sap and store within a git forge (GitHub, GitLab, Gitea) or a local git repository.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		now := time.Now()
-		timeStamp := strconv.FormatInt(now.UnixNano(), 10)
-
-		shellScript := viper.GetString("script")
-		payload, err := os.ReadFile(shellScript)
-		if err != nil {
-			return err
-		}
-
-		// Lets check it is an actual script and someone is not
+		// Lets check they are actual scripts and someone is not
 		// trying sign something non text/plain (e.g. should only be a script)
-		mime, err := mimetype.DetectFile(shellScript)
+		scripts, err := expandScripts(append(viper.GetStringSlice("script"), args...), viper.GetString("interpreter"))
 		if err != nil {
 			return err
 		}
-		if mime.String() != "text/plain; charset=utf-8" {
-			return errors.New("unsupported mimetype")
-		}
-
-		//uncomment when we have all our sining files
-		token := os.Getenv("GITHUB_AUTH_TOKEN")
-		if token == "" {
-			return errors.New("unauthorized: No token present")
+		if len(scripts) == 0 {
+			return errors.New("no scripts to sign, use --script to provide files, directories or globs")
 		}
-
-		// Retrieve idToken from oidc provider
-		idToken, err := oauthflow.OIDConnect(
-			viper.GetString("oidc-issuer"),
-			viper.GetString("oidc-client-id"),
-			viper.GetString("oidc-client-secret"),
-			oauthflow.DefaultIDTokenGetter,
-		)
+		sandboxPolicy, err := declaredSandbox()
 		if err != nil {
 			return err
 		}
-		fmt.Println("\nReceived OpenID Scope retrieved for account:", idToken.Subject)
 
-		// Now OIDC has succeeded, build up the folder structure for saving signing materials
-		storeDir, err := utils.StoreDir(timeStamp)
+		// Materials are written under root as they will be laid out in the
+		// repository, so they can be published later from another machine
+		root := viper.GetString("output-dir")
+		noPush := viper.GetBool("no-push")
 
-		signer, err := signature.NewDefaultECDSASignerVerifier()
-		if err != nil {
-			return err
+		// Make sure the materials can be stored before asking for an identity
+		var store forge.Forge
+		if !noPush {
+			if store, err = newForge(true); err != nil {
+				return err
+			}
 		}
 
-		pub, err := signer.PublicKey(ctx)
+		// Sign with a local key when one is given, otherwise get a Fulcio
+		// certificate for an ephemeral key
+		var key *signingKey
+		if keyFile := viper.GetString("key"); keyFile != "" {
+			key, err = localKeySigner(keyFile)
+		} else {
+			key, err = keylessSigner()
+		}
 		if err != nil {
 			return err
 		}
 
-		pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+		var filesForPR []string
+		for _, script := range scripts {
+			signature, err := key.sign(script.payload)
+			if err != nil {
+				return fmt.Errorf("error occurred during signing of %s: %w", script.repoPath, err)
+			}
 
-		if err != nil {
-			return err
-		}
+			fmt.Println("Sending entry for", script.repoPath, "to transparency log")
+			tlogEntry, err := rekor.Upload(
+				viper.GetString("rekor-server"),
+				key.pubPEM,
+				signature,
+				script.payload,
+			)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Rekor entry successful. Index number: :", tlogEntry.LogIndex)
 
-		proof, _, err := signer.Sign(ctx, []byte(idToken.Subject))
-		if err != nil {
-			return err
-		}
+			// Each script keeps its materials in a directory named after it,
+			// signing it again replaces them
+			dir := manifest.Dir(script.repoPath)
+			if err := clearMaterials(filepath.Join(root, filepath.FromSlash(dir))); err != nil {
+				return err
+			}
 
-		certResp, err := httpclients.GetCert(idToken, proof, pubBytes, viper.GetString("fulcio-server"))
-		if err != nil {
-			switch t := err.(type) {
-			case *operations.SigningCertDefault:
-				if t.Code() == http.StatusInternalServerError {
+			digest := sha256.Sum256(script.payload)
+			artifact := key.artifact
+			artifact.Path = script.repoPath
+			artifact.SHA256 = hex.EncodeToString(digest[:])
+			artifact.Signature = path.Join(dir, manifest.SignatureName)
+			artifact.Rekor = manifest.Rekor{
+				Entry:    path.Join(dir, manifest.RekorName),
+				UUID:     tlogEntry.UUID,
+				LogIndex: tlogEntry.LogIndex,
+			}
+			if key.certificate != nil {
+				artifact.Certificate = path.Join(dir, manifest.CertificateName)
+				artifact.Chain = path.Join(dir, manifest.ChainName)
+			}
+			if key.publicKey != nil {
+				artifact.PublicKey = path.Join(dir, manifest.PublicKeyName)
+			}
+			artifact.Interpreter = script.interpreter
+			artifact.Sandbox = sandboxPolicy
+
+			// Write the manifest linking the script to its materials and sign
+			// it with the same key, so install never has to guess which files
+			// belong together
+			manifestBytes, err := manifest.Marshal(&manifest.Manifest{
+				SchemaVersion: manifest.SchemaVersion,
+				Artifacts:     []manifest.Artifact{artifact},
+			})
+			if err != nil {
+				return err
+			}
+			manifestSig, err := key.sign(manifestBytes)
+			if err != nil {
+				return err
+			}
+
+			materials := []struct {
+				repoPath string
+				content  []byte
+			}{
+				{manifest.Path(script.repoPath), manifestBytes},
+				{path.Join(dir, manifest.SignatureFileName), []byte(base64.StdEncoding.EncodeToString(manifestSig))},
+				{artifact.Signature, []byte(base64.StdEncoding.EncodeToString(signature))},
+				{artifact.Certificate, key.certificate},
+				{artifact.Chain, key.chain},
+				{artifact.PublicKey, key.publicKey},
+			}
+			for _, f := range materials {
+				if f.repoPath == "" {
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(f.repoPath)), f.content, 0644); err != nil {
 					return err
 				}
-			default:
+				filesForPR = append(filesForPR, materialFile(root, f.repoPath))
+			}
+
+			// Keep the log entry and inclusion proof so install can check them
+			if err := rekor.WriteEntry(filepath.Join(root, filepath.FromSlash(artifact.Rekor.Entry)), tlogEntry); err != nil {
 				return err
 			}
-			os.Exit(1)
+			filesForPR = append(filesForPR, materialFile(root, artifact.Rekor.Entry))
+
+			scriptFile := script.localPath + ":" + script.repoPath
+			if filepath.Clean(root) != "." {
+				// Keep a copy of the script next to its materials for publish
+				if err := copyScript(script.localPath, filepath.Join(root, script.repoPath)); err != nil {
+					return err
+				}
+				scriptFile = materialFile(root, script.repoPath)
+			}
+			filesForPR = append(filesForPR, scriptFile)
 		}
 
-		clientPEM, rootPEM := pem.Decode([]byte(certResp.Payload))
+		if noPush {
+			fmt.Println("Signed materials written to", filepath.Join(root, manifest.Root))
+			fmt.Println("Publish them with: sap publish", root)
+			return nil
+		}
+		return publishMaterials(store, filesForPR)
+	},
+}
 
-		certPEM := pem.EncodeToMemory(clientPEM)
+func init() {
+	rootCmd.AddCommand(signCmd)
+	signCmd.PersistentFlags().StringVar(&fulcioAddr, "fulcio-server", "https://fulcio.sigstore.dev", "address of sigstore PKI server")
+	signCmd.PersistentFlags().String("oidc-issuer", "https://oauth2.sigstore.dev/auth", "OIDC provider to be used to issue ID token")
+	signCmd.PersistentFlags().String("oidc-client-id", "sigstore", "client ID for application")
+	signCmd.PersistentFlags().String("oidc-client-secret", "", "client secret for application")
+	signCmd.PersistentFlags().String("identity-token", "", "OIDC identity token, or a file holding one, to use instead of logging in")
+	signCmd.PersistentFlags().String("identity-token-audience", "sigstore", "Audience of identity tokens requested from the CI system")
+	signCmd.PersistentFlags().Bool("oidc-device-flow", false, "Log in with the device code flow instead of opening a browser, for headless terminals")
+	signCmd.PersistentFlags().String("key", "", "Sign with this encrypted private key instead of a Fulcio certificate, the password is read from "+passwordEnv+" or prompted for")
+
+	signCmd.PersistentFlags().Bool("no-push", false, "Only write the signed materials, publish them later with sap publish")
+	signCmd.PersistentFlags().String("output-dir", ".", "Directory standing for the top of the repository to write the signed materials under")
+	addPublishFlags(signCmd.PersistentFlags())
+	signCmd.PersistentFlags().StringSlice("script", nil, "Target scripts to sign, as files, directories or globs (can be repeated)")
+	signCmd.PersistentFlags().Bool("sandbox", false, "Record a sandbox policy for the scripts, built from the --sandbox-* flags")
+	signCmd.PersistentFlags().Bool("sandbox-network", false, "Allow the sandboxed scripts to use the network")
+	addSandboxFlags(signCmd.PersistentFlags())
+	signCmd.PersistentFlags().String("interpreter", "", "Interpreter to record for the scripts instead of detecting it: "+strings.Join(interpreter.Names(), ", "))
+	if err := viper.BindPFlags(signCmd.PersistentFlags()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
 
-		rootBlock, _ := pem.Decode([]byte(rootPEM))
-		if rootBlock == nil {
-			return err
-		}
+// scriptFile is a script to sign and the path it is committed under.
+type scriptFile struct {
+	localPath   string
+	repoPath    string
+	payload     []byte
+	interpreter string
+}
 
-		certBlock, _ := pem.Decode([]byte(certPEM))
-		if certBlock == nil {
-			return err
+// expandScripts resolves files, directories and globs into the list of
+// scripts to sign. Files found by walking a directory are skipped when they
+// are not a supported type, anything named explicitly must be supported.
+// The interpreter of each script is detected unless override names one.
+func expandScripts(patterns []string, override string) ([]scriptFile, error) {
+	var scripts []scriptFile
+	seen := map[string]bool{}
+
+	add := func(file string, explicit bool) error {
+		repoPath := filepath.ToSlash(filepath.Clean(file))
+		if seen[repoPath] {
+			return nil
 		}
-
-		cert, err := x509.ParseCertificate(certBlock.Bytes)
+		// Scripts are committed under their path relative to the repository root
+		if filepath.IsAbs(file) || repoPath == ".." || strings.HasPrefix(repoPath, "../") {
+			return fmt.Errorf("%s: script must be a relative path inside the repository", file)
+		}
+		payload, err := os.ReadFile(file)
 		if err != nil {
 			return err
 		}
-
-		fmt.Println("Received signing cerificate with serial number: ", cert.SerialNumber)
-
-		signature, signedVal, err := signer.Sign(ctx, payload)
+		var handler interpreter.Handler
+		if override != "" {
+			handler, err = interpreter.Lookup(override)
+		} else {
+			handler, err = interpreter.Detect(file, payload)
+		}
 		if err != nil {
-			panic(fmt.Sprintf("Error occurred while during artifact signing: %s", err))
+			if explicit {
+				return err
+			}
+			return nil
 		}
+		seen[repoPath] = true
+		scripts = append(scripts, scriptFile{localPath: file, repoPath: repoPath, payload: payload, interpreter: handler.Name})
+		return nil
+	}
 
-		fmt.Println("Sending entry to transparency log")
-		tlogEntry, err := tlog.UploadToRekor(
-			certPEM,
-			signedVal,
-			signature,
-			viper.GetString("rekor-server"),
-			payload,
-		)
-		if err != nil {
-			return err
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no scripts match", pattern)
+			}
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				if err := add(match, true); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			err = filepath.WalkDir(match, func(file string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					// Never pick up git internals or previously written materials
+					if file != match && strings.HasPrefix(d.Name(), ".") {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !d.Type().IsRegular() {
+					return nil
+				}
+				return add(file, false)
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
-		fmt.Println("Rekor entry successful. Index number: :", tlogEntry)
+	}
+	return scripts, nil
+}
 
-		// dump signature to file
-		sigFile := fmt.Sprintf("%s/signature_%s.bin", storeDir, timeStamp)
-		fulcioCert := fmt.Sprintf("%s/fulcio_cert_%s.pem", storeDir, timeStamp)
+// signingKey signs the scripts of one run and describes how to verify them.
+type signingKey struct {
+	sign func(payload []byte) ([]byte, error)
+	// pubPEM is the certificate or public key recorded in the log entries.
+	pubPEM []byte
+	// artifact holds the mode and signer shared by every script of the run.
+	artifact manifest.Artifact
+	// certificate and chain, or publicKey, are stored with the signature of
+	// each script.
+	certificate []byte
+	chain       []byte
+	publicKey   []byte
+}
 
-		// convert signature to base64
-		sigBase64 := base64.StdEncoding.EncodeToString(signature)
+// keylessSigner authenticates the signer with OIDC and gets a Fulcio
+// certificate for an ephemeral key.
+func keylessSigner() (*signingKey, error) {
+	// Retrieve idToken from oidc provider
+	idToken, err := identityToken()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("\nReceived OpenID Scope retrieved for account:", idToken.Subject)
 
-		f, err := os.Create(sigFile)
+	signer, err := signature.NewDefaultECDSASignerVerifier()
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			fmt.Println(err)
-		}
-		defer f.Close()
+	pub, err := signer.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		_, err2 := f.WriteString(sigBase64)
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
 
-		if err2 != nil {
-			fmt.Println(err)
-		}
+	proof, _, err := signer.Sign(ctx, []byte(idToken.Subject))
+	if err != nil {
+		return nil, err
+	}
 
-		err = os.WriteFile(fulcioCert, certPEM, 0644)
-		if err != nil {
-			fmt.Println(err)
+	certResp, err := httpclients.GetCert(idToken, proof, pubBytes, viper.GetString("fulcio-server"))
+	if err != nil {
+		switch t := err.(type) {
+		case *operations.SigningCertDefault:
+			if t.Code() == http.StatusInternalServerError {
+				return nil, err
+			}
+		default:
+			return nil, err
 		}
+		os.Exit(1)
+	}
 
-		// Setup GH token via oauth2
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: token},
-		)
-		tc := oauth2.NewClient(ctx, ts)
+	clientPEM, rootPEM := pem.Decode([]byte(certResp.Payload))
+	if clientPEM == nil {
+		return nil, errors.New("no signing certificate in the Fulcio response")
+	}
 
-		// Ontain instance of google/go-github client
-		client := github.NewClient(tc)
+	certPEM := pem.EncodeToMemory(clientPEM)
 
-		ref, err := githubapi.GetRef(ctx, client, viper.GetString("owner"), viper.GetString("repo"),
-			timeStamp, viper.GetString("commit-branch"),
-			viper.GetString("base-branch"))
-		if err != nil {
-			return errors.New(fmt.Sprintf("unable to get/create the commit reference: %s", err))
-		}
-		if ref == nil {
-			return errors.New("no error where returned but the reference is nil")
-		}
+	rootBlock, _ := pem.Decode([]byte(rootPEM))
+	if rootBlock == nil {
+		return nil, errors.New("no certificate chain in the Fulcio response")
+	}
+
+	cert, err := x509.ParseCertificate(clientPEM.Bytes)
+	if err != nil {
+		return nil, err
+	}
 
-		filesForPR := fmt.Sprintf("%s,%s,%s", sigFile, fulcioCert, shellScript)
-		tree, err := githubapi.GetTree(ctx, client, ref, filesForPR, viper.GetString("owner"),
-			viper.GetString("repo"))
+	fmt.Println("Received signing cerificate with serial number: ", cert.SerialNumber)
+
+	identity := verify.SignerIdentity(cert)
+	return &signingKey{
+		sign: func(payload []byte) ([]byte, error) {
+			sig, _, err := signer.Sign(ctx, payload)
+			return sig, err
+		},
+		pubPEM: certPEM,
+		artifact: manifest.Artifact{
+			Mode: manifest.ModeKeyless,
+			Signer: manifest.Signer{
+				Identities: identity.Subjects(),
+				Issuer:     identity.Issuer,
+			},
+		},
+		// One certificate covers every script signed in this run. The chain
+		// Fulcio returned is stored next to the leaf, install uses it to
+		// build the path to the trusted root
+		certificate: certPEM,
+		chain:       rootPEM,
+	}, nil
+}
+
+// identityToken returns the OIDC identity token Fulcio binds the
+// certificate to: the one given with --identity-token, one provided by the
+// CI system, or one obtained with the device code flow or in the browser.
+func identityToken() (*oauthflow.OIDCIDToken, error) {
+	raw := viper.GetString("identity-token")
+	if raw != "" {
+		var err error
+		if raw, err = idtoken.Read(raw); err != nil {
+			return nil, err
+		}
+	} else {
+		token, source, err := idtoken.Ambient(ctx, viper.GetString("identity-token-audience"))
 		if err != nil {
-			return errors.New(fmt.Sprintf("unable to create the tree based on the provided files: %s\n", err))
+			return nil, err
 		}
-
-		if err := githubapi.PushCommit(ctx, client, ref, tree, viper.GetString("owner"),
-			viper.GetString("repo"),
-			viper.GetString("author-name"),
-			viper.GetString("author-email"),
-			viper.GetString("commit-message")); err != nil {
-			return errors.New(fmt.Sprintf("unable to create the commit: %s\n", err))
+		if token != "" {
+			fmt.Println("Using the identity token from", source)
 		}
+		raw = token
+	}
+	if raw != "" {
+		// Fulcio verifies the token, it is only parsed here for the subject
+		getter := &oauthflow.StaticTokenGetter{RawToken: raw}
+		return getter.GetIDToken(nil, oauth2.Config{})
+	}
 
-		if err := githubapi.CreatePR(ctx, client, viper.GetString("merge-branch-owner"),
-			viper.GetString("merge-repo"),
-			viper.GetString("owner"),
-			viper.GetString("commit-branch"),
-			viper.GetString("repo"),
-			viper.GetString("pr-title"),
-			viper.GetString("merge-branch"),
-			viper.GetString("pr-text"),
-		); err != nil {
-			return errors.New(fmt.Sprintf("error while creating the pull request: %s", err))
+	var getter oauthflow.TokenGetter = oauthflow.DefaultIDTokenGetter
+	if viper.GetBool("oidc-device-flow") {
+		codeURL, tokenURL, err := idtoken.DeviceEndpoints(ctx, viper.GetString("oidc-issuer"))
+		if err != nil {
+			return nil, err
 		}
+		getter = oauthflow.NewDeviceFlowTokenGetter(viper.GetString("oidc-issuer"), codeURL, tokenURL)
+	}
+	return oauthflow.OIDConnect(
+		viper.GetString("oidc-issuer"),
+		viper.GetString("oidc-client-id"),
+		viper.GetString("oidc-client-secret"),
+		getter,
+	)
+}
 
-		return nil
+// localKeySigner signs with the encrypted private key in keyFile. The
+// public key is committed for reference, installers verify with the key
+// they are configured with.
+func localKeySigner(keyFile string) (*signingKey, error) {
+	password, err := readPassword(false)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.LoadPrivateKey(keyFile, password)
+	if err != nil {
+		return nil, err
+	}
+	pubPEM, err := keys.MarshalPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	identity, err := verify.KeyIdentity(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Signing with key", identity.Key)
+
+	return &signingKey{
+		sign: func(payload []byte) ([]byte, error) {
+			return keys.Sign(key, payload)
+		},
+		pubPEM: pubPEM,
+		artifact: manifest.Artifact{
+			Mode: manifest.ModeKey,
+			Signer: manifest.Signer{
+				Identities: identity.Subjects(),
+			},
+		},
+		publicKey: pubPEM,
+	}, nil
+}
 
-	},
+// materialFile returns the forge.Commit file entry of the material at
+// repoPath when the repository is laid out under root.
+func materialFile(root, repoPath string) string {
+	if filepath.Clean(root) == "." {
+		return repoPath
+	}
+	return filepath.Join(root, repoPath) + ":" + filepath.ToSlash(repoPath)
 }
 
-func init() {
-	rootCmd.AddCommand(signCmd)
-	signCmd.PersistentFlags().StringVar(&fulcioAddr, "fulcio-server", "https://fulcio.sigstore.dev", "address of sigstore PKI server")
-	signCmd.PersistentFlags().StringVar(&rekorAddr, "rekor-server", "https://rekor.sigstore.dev", "address of rekor STL server")
-	signCmd.PersistentFlags().String("oidc-issuer", "https://oauth2.sigstore.dev/auth", "OIDC provider to be used to issue ID token")
-	signCmd.PersistentFlags().String("oidc-client-id", "sigstore", "client ID for application")
-	signCmd.PersistentFlags().String("oidc-client-secret", "", "client secret for application")
+// copyScript copies the script at src to dst, keeping its mode.
+func copyScript(src, dst string) error {
+	if absSrc, err := filepath.Abs(src); err == nil {
+		if absDst, err := filepath.Abs(dst); err == nil && absSrc == absDst {
+			return nil
+		}
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, info.Mode().Perm())
+}
 
-	signCmd.PersistentFlags().String("author-email", "sign@sigstore.dev", "Used for the Author email")
-	signCmd.PersistentFlags().String("author-name", "sigstore", "Used for the Author Name, default is \"sigstore\"")
-	signCmd.PersistentFlags().String("base-branch", "main", "Name of branch to create the commit-branch from. (default \"main\")")
-	signCmd.PersistentFlags().String("commit-branch", "", "Name of branch to create the commit in. If it does not already exists, it will be created using the base-branch parameter")
-	signCmd.PersistentFlags().String("commit-message", "", "Content of the commit message.")
-	signCmd.PersistentFlags().String("merge-branch", "main", "Name of branch to create the PR against (the one you want to merge your branch in via the PR). (default \"main\")")
-	signCmd.PersistentFlags().String("merge-repo", "", "Name of repo to create the PR against. If not specified, the value of the --repo flag will be used.")
-	signCmd.PersistentFlags().String("merge-repo-owner", "", "Name of the owner (user or org) of the repo to create the PR against. If not specified, the value of the --owner flag will be used.\"")
-	signCmd.PersistentFlags().String("pr-text", "", "Text to put in the description of the pull request")
-	signCmd.PersistentFlags().String("pr-title", "", " Title of the pull request. If not specified, no pull request will be created")
-	signCmd.PersistentFlags().String("script", "", "Target script to sign")
-	if err := viper.BindPFlags(signCmd.PersistentFlags()); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// clearMaterials removes the materials of an earlier signature from dir, so
+// none are left over when the signing mode changes, and creates dir.
+func clearMaterials(dir string) error {
+	for _, name := range manifest.MaterialNames {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
 	}
+	return os.MkdirAll(dir, 0755)
 }
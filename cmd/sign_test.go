@@ -0,0 +1,373 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lukehinds/sap/pkg/rekor/rekortest"
+	"github.com/lukehinds/sap/pkg/verify"
+	"github.com/spf13/viper"
+)
+
+const (
+	testSigner       = "ci@example.com"
+	testRequestToken = "actions-request-token"
+)
+
+// oidcStandIn issues identity tokens the way the GitHub Actions token
+// endpoint does, and remembers them so the Fulcio stand-in can check them.
+type oidcStandIn struct {
+	URL    string
+	issued sync.Map
+}
+
+func newOIDCStandIn(t *testing.T) *oidcStandIn {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &oidcStandIn{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testRequestToken {
+			http.Error(w, "bad request token", http.StatusUnauthorized)
+			return
+		}
+		token, err := signJWT(key, map[string]interface{}{
+			"iss":            o.URL,
+			"sub":            "repo:jdoe/scripts:ref:refs/heads/main",
+			"aud":            r.URL.Query().Get("audience"),
+			"email":          testSigner,
+			"email_verified": true,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		o.issued.Store(token, true)
+		_ = json.NewEncoder(w).Encode(map[string]string{"value": token})
+	}))
+	t.Cleanup(srv.Close)
+	o.URL = srv.URL
+	return o
+}
+
+// signJWT returns claims as a compact ES256 JWT.
+func signJWT(key *ecdsa.PrivateKey, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// fulcioStandIn certifies keys for holders of a token issued by the OIDC
+// stand-in, binding the certificate to the email in the token.
+type fulcioStandIn struct {
+	URL     string
+	RootPEM []byte
+}
+
+func newFulcioStandIn(t *testing.T, oidc *oidcStandIn) *fulcioStandIn {
+	t.Helper()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio stand-in"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fulcioStandIn{RootPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER})}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/signingCert" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		if _, ok := oidc.issued.Load(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); !ok {
+			http.Error(w, "unknown identity token", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+			SignedEmailAddress []byte `json:"signedEmailAddress"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pub, err := x509.ParsePKIXPublicKey(req.PublicKey.Content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The signer proves possession of the key by signing the email
+		digest := sha256.Sum256([]byte(testSigner))
+		ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+		if !ok || !ecdsa.VerifyASN1(ecdsaPub, digest[:], req.SignedEmailAddress) {
+			http.Error(w, "bad proof of possession", http.StatusBadRequest)
+			return
+		}
+		leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:   big.NewInt(time.Now().UnixNano()),
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(10 * time.Minute),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			EmailAddresses: []string{testSigner},
+			ExtraExtensions: []pkix.Extension{
+				{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}, Value: []byte(oidc.URL)},
+			},
+		}, root, pub, rootKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.WriteHeader(http.StatusCreated)
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+		_, _ = w.Write(f.RootPEM)
+	}))
+	t.Cleanup(srv.Close)
+	f.URL = srv.URL
+	return f
+}
+
+// newBareRepo creates a bare repository whose main branch has one commit.
+func newBareRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "repo.git")
+	work := t.TempDir()
+	run := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	run(work, "init", "--quiet", "--bare", dir)
+	run(work, "init", "--quiet")
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("# scripts\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "add", "README.md")
+	run(work, "commit", "--quiet", "-m", "init")
+	run(work, "push", "--quiet", dir, "HEAD:refs/heads/main")
+	return dir
+}
+
+func writeFile(t *testing.T, file string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// resetViper drops the configuration the test sets once it is done. The
+// flag bindings made at init are restored, running commands bind their own.
+func resetViper(t *testing.T) {
+	t.Cleanup(func() {
+		viper.Reset()
+		if err := viper.BindPFlags(rootCmd.PersistentFlags()); err != nil {
+			t.Error(err)
+		}
+		if err := viper.BindPFlags(signCmd.PersistentFlags()); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestSignThenVerify signs a script in CI with an ambient identity token,
+// commits it to a local repository and verifies it from there, with
+// stand-ins for the OIDC provider, Fulcio and Rekor.
+func TestSignThenVerify(t *testing.T) {
+	oidc := newOIDCStandIn(t)
+	fulcio := newFulcioStandIn(t, oidc)
+	tlog := rekortest.NewServer(t)
+	repoDir := newBareRepo(t)
+	resetViper(t)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SIGSTORE_ID_TOKEN", "")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", oidc.URL+"/token")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", testRequestToken)
+
+	work := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	writeFile(t, "install.sh", []byte("#!/bin/bash\necho hello\n"))
+
+	rootCmd.SetArgs([]string{"sign",
+		"--script", "install.sh",
+		"--local-repo", repoDir,
+		"--commit-branch", "sap",
+		"--base-branch", "main",
+		"--fulcio-server", fulcio.URL,
+		"--rekor-server", tlog.URL,
+	})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	writeFile(t, filepath.Join(home, "fulcio.pem"), fulcio.RootPEM)
+	writeFile(t, filepath.Join(home, "rekor.pub"), tlog.PublicKeyPEM())
+	viper.Set("fulcio-root", filepath.Join(home, "fulcio.pem"))
+	viper.Set("rekor-pubkey", filepath.Join(home, "rekor.pub"))
+	viper.Set("ref", "sap")
+
+	tests := []struct {
+		name     string
+		identity string
+		issuer   string
+		allowAny bool
+		want     int
+	}{
+		{"allowed signer", testSigner, oidc.URL, false, 0},
+		{"other signer", "someone@example.com", oidc.URL, false, verify.ClassIdentity.ExitCode()},
+		{"allowed issuer", "", oidc.URL, false, 0},
+		{"no policy", "", "", false, 1},
+		{"any signer allowed", "", "", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var identities, issuers []string
+			if tt.identity != "" {
+				identities = []string{tt.identity}
+			}
+			if tt.issuer != "" {
+				issuers = []string{tt.issuer}
+			}
+			viper.Set("allowed-identity", identities)
+			viper.Set("allowed-issuer", issuers)
+			viper.Set("allow-any-signer", tt.allowAny)
+			if got := verifyScript([]string{"install.sh"}); got != tt.want {
+				t.Errorf("verify exited with %d, want %d", got, tt.want)
+			}
+		})
+	}
+	viper.Set("allowed-issuer", []string{oidc.URL})
+	viper.Set("allow-any-signer", false)
+
+	// Merge the branch and release it, which verifies the bundle first
+	git := func(args ...string) (string, error) {
+		out, err := exec.Command("git", append([]string{"-C", repoDir}, args...)...).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+	if out, err := git("update-ref", "refs/heads/main", "refs/heads/sap"); err != nil {
+		t.Fatalf("git update-ref: %v\n%s", err, out)
+	}
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	releases := []struct {
+		name     string
+		identity string
+		tag      string
+		err      bool
+	}{
+		{"other signer", "someone@example.com", "v0", true},
+		{"allowed signer", testSigner, "v1", false},
+	}
+	for _, tt := range releases {
+		t.Run("release by "+tt.name, func(t *testing.T) {
+			viper.Set("allowed-identity", []string{tt.identity})
+			bundleDir := t.TempDir()
+			rootCmd.SetArgs([]string{"release",
+				"--local-repo", repoDir,
+				"--commit-branch", "sap",
+				"--tag", tt.tag,
+				"--bundle-dir", bundleDir,
+				"--rekor-server", tlog.URL,
+			})
+			err := rootCmd.Execute()
+			if tt.err != (err != nil) {
+				t.Fatalf("release: %v", err)
+			}
+			_, tagErr := git("rev-parse", "--verify", "refs/tags/"+tt.tag)
+			_, bundleErr := os.Stat(filepath.Join(bundleDir, "install.sh.sapbundle"))
+			if tt.err && (tagErr == nil || bundleErr == nil) {
+				t.Error("release of a script that does not verify created a tag or bundle")
+			}
+			if !tt.err && (tagErr != nil || bundleErr != nil) {
+				t.Errorf("release created no tag or bundle: %v, %v", tagErr, bundleErr)
+			}
+		})
+	}
+}
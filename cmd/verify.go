@@ -0,0 +1,239 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/interpreter"
+	"github.com/lukehinds/sap/pkg/keys"
+	"github.com/lukehinds/sap/pkg/rekor"
+	"github.com/lukehinds/sap/pkg/utils"
+	"github.com/lukehinds/sap/pkg/verify"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [script]",
+	Short: "sap verify a signed script without running it",
+	Long: `Run every check install performs on a signed script, print a pass / fail
+report and exit. Nothing is ever executed.
+
+Scripts are verified as stored on the forge, or in a local checkout given
+with --dir. The exit code tells why verification failed:
+
+  1  configuration error
+  2  missing or malformed materials
+  3  signature or digest mismatch
+  4  certificate or key not trusted
+  5  signer identity not allowed
+  6  transparency log check failed
+  7  interpreter not allowed`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(verifyScript(args))
+	},
+}
+
+// verifyScript verifies the named script and returns the exit code.
+func verifyScript(args []string) int {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	owner := viper.GetString("owner")
+	repo := viper.GetString("repo")
+
+	opts, err := verifyOptions(owner, repo, !viper.GetBool("offline"))
+	if err != nil {
+		pterm.Error.Println(err)
+		return 1
+	}
+
+	var (
+		read         materialReader
+		manifestPath string
+	)
+	if dir := viper.GetString("dir"); dir != "" {
+		manifestPath, err = checkoutManifest(dir, name)
+		if err != nil {
+			pterm.Error.Println(err)
+			return verify.ClassMaterials.ExitCode()
+		}
+		read = checkoutReader(dir)
+		pterm.Info.Println("Verifying checkout " + dir)
+	} else {
+		store, err := newForge(false)
+		if err != nil {
+			pterm.Error.Println(err)
+			return 1
+		}
+		sha, err := store.ResolveCommit(ctx, viper.GetString("tag"), viper.GetString("ref"), viper.GetString("commit"))
+		if err != nil {
+			pterm.Error.Println(err)
+			return verify.ClassMaterials.ExitCode()
+		}
+		manifestPath, err = forgeManifest(store, sha, name)
+		if err != nil {
+			pterm.Error.Println(err)
+			return verify.ClassMaterials.ExitCode()
+		}
+		dir, err := utils.TempDir()
+		if err != nil {
+			pterm.Error.Println(err)
+			return 1
+		}
+		defer os.RemoveAll(dir)
+		read = forgeReader(store, sha, dir)
+		pterm.Info.Println("Verifying commit " + sha)
+	}
+
+	materials, err := loadMaterials(read, manifestPath, name)
+	if err != nil {
+		pterm.Error.Println(err)
+		return verify.ClassMaterials.ExitCode()
+	}
+	report := verify.Verify(materials, opts)
+	printReport(materials.Artifact.Path, report)
+	if failure := report.Failure(); failure != nil {
+		return failure.Class.ExitCode()
+	}
+	return 0
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	addRevisionFlags(verifyCmd.Flags())
+	addVerifyFlags(verifyCmd.Flags())
+	verifyCmd.Flags().String("dir", "", "Verify a local checkout instead of the forge")
+	verifyCmd.Flags().Bool("offline", false, "Do not look the entry up on the Rekor server, rely on the committed inclusion proof")
+}
+
+// addRevisionFlags adds the flags selecting the revision to read scripts from.
+func addRevisionFlags(flags *pflag.FlagSet) {
+	flags.String("tag", "latest", "The release tag (version)")
+	flags.String("ref", "", "Branch or tag to read scripts from, takes precedence over --tag")
+	flags.String("commit", "", "Commit SHA to read scripts from, takes precedence over --tag")
+}
+
+// addVerifyFlags adds the flags configuring what is trusted.
+func addVerifyFlags(flags *pflag.FlagSet) {
+	flags.String("fulcio-root", "", "PEM bundle of trusted Fulcio root certificates")
+	flags.String("public-key", "", "Public key of scripts signed with sap sign --key, keyless scripts are refused when set")
+	flags.String("rekor-pubkey", "", "PEM encoded public key of the Rekor transparency log")
+	flags.StringSlice("allowed-identity", nil, "Signer email or URI allowed to sign scripts for the repo (can be repeated)")
+	flags.StringSlice("allowed-issuer", nil, "OIDC issuer allowed to authenticate the signer (can be repeated)")
+	flags.Bool("allow-any-signer", false, "Accept keyless scripts signed by anyone when no identity policy is configured")
+	flags.StringSlice("allowed-interpreter", nil, "Interpreter scripts may declare (can be repeated), defaults to all of: "+strings.Join(interpreter.Names(), ", "))
+}
+
+// verifyOptions loads the trusted roots, Rekor key and identity policy for
+// owner/repo. With online set the entry is also looked up on --rekor-server.
+func verifyOptions(owner, repo string, online bool) (verify.Options, error) {
+	var opts verify.Options
+	if viper.GetString("ref") != "" && viper.GetString("commit") != "" {
+		return opts, fmt.Errorf("--ref and --commit can not be used together")
+	}
+	// Scripts signed with a key are verified with the configured public
+	// key, and no Fulcio root is needed
+	var (
+		roots     *x509.CertPool
+		publicKey crypto.PublicKey
+		err       error
+	)
+	if keyFile := viper.GetString("public-key"); keyFile != "" {
+		publicKey, err = keys.LoadPublicKey(keyFile)
+	} else {
+		roots, err = verify.LoadRoots(viper.GetString("fulcio-root"))
+	}
+	if err != nil {
+		return opts, err
+	}
+	rekorPub, err := rekor.LoadPublicKey(viper.GetString("rekor-pubkey"))
+	if err != nil {
+		return opts, err
+	}
+	policy, err := identityPolicy(owner, repo)
+	if err != nil {
+		return opts, fmt.Errorf("unable to load identity policy: %w", err)
+	}
+	// Anyone can get a Fulcio certificate, keyless scripts need a policy
+	// naming who may sign them unless any signer is explicitly accepted
+	allowAny := viper.GetBool("allow-any-signer")
+	if policy.Empty() && publicKey == nil {
+		if !allowAny {
+			return opts, fmt.Errorf("no identity policy configured for %s/%s: set --allowed-identity or --allowed-issuer, or pass --allow-any-signer", owner, repo)
+		}
+		pterm.Warning.Println("No identity policy configured for " + owner + "/" + repo + ", accepting any signer")
+	}
+	opts = verify.Options{
+		Roots:          roots,
+		Policy:         policy,
+		RekorPublicKey: rekorPub,
+		PublicKey:      publicKey,
+		Interpreters:   viper.GetStringSlice("allowed-interpreter"),
+		AllowAnySigner: allowAny,
+	}
+	if online {
+		opts.RekorURL = viper.GetString("rekor-server")
+	}
+	return opts, nil
+}
+
+// printReport prints one line per check run on script.
+func printReport(script string, r *verify.Report) {
+	for _, c := range r.Checks {
+		if c.Err != nil {
+			pterm.Error.Printfln("%s: %s: %v", c.Class, c.Name, c.Err)
+			continue
+		}
+		pterm.Success.Printfln("%s: %s", c.Class, c.Name)
+	}
+	if r.Passed() {
+		pterm.Info.Println(script + " signed by: " + r.Signer.String())
+		pterm.Info.Println(script + " runs with: " + r.Interpreter.Name)
+	}
+}
+
+// identityPolicy builds the signer policy for owner/repo from the
+// --allowed-identity and --allowed-issuer flags and any matching entries
+// in the policy section of the config file.
+func identityPolicy(owner, repo string) (verify.IdentityPolicy, error) {
+	policy := verify.IdentityPolicy{
+		Owner:      owner,
+		Repo:       repo,
+		Identities: viper.GetStringSlice("allowed-identity"),
+		Issuers:    viper.GetStringSlice("allowed-issuer"),
+	}
+	var configured []verify.IdentityPolicy
+	if err := viper.UnmarshalKey("policy", &configured); err != nil {
+		return policy, err
+	}
+	for _, p := range configured {
+		if p.Matches(owner, repo) {
+			policy = policy.Merge(p)
+		}
+	}
+	return policy, nil
+}
@@ -15,8 +15,13 @@
 
 package main
 
-import "github.com/lukehinds/sap/cmd"
+import (
+	"github.com/lukehinds/sap/cmd"
+	"github.com/lukehinds/sap/pkg/sandbox"
+)
 
 func main() {
+	// sandboxed scripts are started through sap itself
+	sandbox.Init()
 	cmd.Execute()
 }
@@ -0,0 +1,108 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bundle packs a signed script and everything needed to verify it
+// into a single file, so it can be verified and installed without network
+// access.
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lukehinds/sap/pkg/download"
+)
+
+// SchemaVersion is the bundle format written by this version of sap.
+const SchemaVersion = 1
+
+// Bundle holds the materials of one script, keyed by their path in the
+// repository. Nothing in it is trusted: the materials are verified exactly
+// as if they had been read from the forge.
+type Bundle struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Repo identifies the repository, see forge.Location.ID.
+	Repo  string `json:"repo"`
+	Owner string `json:"owner,omitempty"`
+	Name  string `json:"name,omitempty"`
+	// Commit is the SHA the materials were read at.
+	Commit   string `json:"commit"`
+	Manifest string `json:"manifest"`
+	// Script is the path of the bundled script.
+	Script string            `json:"script"`
+	Files  map[string][]byte `json:"files"`
+}
+
+// Load reads a bundle file.
+func Load(file string) (*Bundle, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// A bundle holds a handful of files, each of them bounded like a download
+	if err := download.CheckSize(info.Size(), 8*download.DefaultLimits.MaxSize); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	var b Bundle
+	if err := json.NewDecoder(f).Decode(&b); err != nil {
+		return nil, fmt.Errorf("%s is not a sap bundle: %w", file, err)
+	}
+	switch {
+	case b.SchemaVersion == 0:
+		return nil, fmt.Errorf("%s is not a sap bundle: no schema version", file)
+	case b.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("bundle schema version %d is newer than the supported version %d, upgrade sap", b.SchemaVersion, SchemaVersion)
+	case b.Manifest == "" || b.Script == "":
+		return nil, fmt.Errorf("%s is not a sap bundle: no manifest or script", file)
+	}
+	return &b, nil
+}
+
+// Read returns the bundled content of repoPath.
+func (b *Bundle) Read(repoPath string) ([]byte, error) {
+	content, ok := b.Files[repoPath]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the bundle", repoPath)
+	}
+	return content, nil
+}
+
+// Write writes the bundle to file, refusing to replace an existing file.
+func (b *Bundle) Write(file string) error {
+	if b.Files == nil {
+		return errors.New("empty bundle")
+	}
+	b.SchemaVersion = SchemaVersion
+	content, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
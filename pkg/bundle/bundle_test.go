@@ -0,0 +1,97 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/download"
+)
+
+func testBundle() *Bundle {
+	return &Bundle{
+		Repo:     "github.com/jdoe/scripts",
+		Owner:    "jdoe",
+		Name:     "scripts",
+		Commit:   strings.Repeat("a", 40),
+		Manifest: ".sap/install.sh/manifest.json",
+		Script:   "install.sh",
+		Files:    map[string][]byte{"install.sh": []byte("echo hi\n")},
+	}
+}
+
+func TestWriteLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "install.sh.sapbundle")
+	if err := testBundle().Write(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := testBundle().Write(file); err == nil {
+		t.Error("Write over an existing bundle succeeded")
+	}
+	b, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.SchemaVersion != SchemaVersion || b.Repo != "github.com/jdoe/scripts" || b.Script != "install.sh" {
+		t.Errorf("Load = %+v", b)
+	}
+	if content, err := b.Read("install.sh"); err != nil || string(content) != "echo hi\n" {
+		t.Errorf("Read(install.sh) = %q, %v", content, err)
+	}
+	if _, err := b.Read("other.sh"); err == nil {
+		t.Error("Read of a file that is not bundled succeeded")
+	}
+	if err := (&Bundle{}).Write(filepath.Join(t.TempDir(), "empty")); err == nil {
+		t.Error("Write of an empty bundle succeeded")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	saved := download.DefaultLimits
+	download.DefaultLimits.MaxSize = 64
+	t.Cleanup(func() { download.DefaultLimits = saved })
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "valid", content: `{"schemaVersion":1,"manifest":"m.json","script":"s.sh"}`},
+		{name: "too large", content: `{"schemaVersion":1,"manifest":"m.json","script":"s.sh","files":{"s.sh":"` + strings.Repeat("A", 8*64) + `"}}`, err: "larger than"},
+		{name: "not json", content: "#!/bin/sh\n", err: "not a sap bundle"},
+		{name: "no schema version", content: `{"manifest":"m.json","script":"s.sh"}`, err: "no schema version"},
+		{name: "newer schema version", content: `{"schemaVersion":2,"manifest":"m.json","script":"s.sh"}`, err: "upgrade sap"},
+		{name: "no script", content: `{"schemaVersion":1,"manifest":"m.json"}`, err: "no manifest or script"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "test.sapbundle")
+			if err := os.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(file)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Error(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Load = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache keeps verified signed materials so they can be installed
+// again without the forge.
+//
+// Files are stored once under objects/, named by their sha256. Each
+// repository commit has an entry under entries/ mapping the repository
+// paths of its materials to those objects, and each verified script to its
+// manifest.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrNotCached is returned for files the cache does not hold.
+var ErrNotCached = errors.New("not cached")
+
+// Cache is a cache directory.
+type Cache struct {
+	dir string
+}
+
+// Entry describes the cached materials of one repository commit.
+type Entry struct {
+	// Repo identifies the repository, see forge.Location.ID.
+	Repo  string `json:"repo"`
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+	// Commit is the SHA the materials were read at.
+	Commit string `json:"commit"`
+	// Manifests maps each verified script to the path of its manifest, every
+	// script has its own.
+	Manifests map[string]string `json:"manifests,omitempty"`
+	// Manifest is the single manifest of entries stored before Manifests.
+	Manifest string `json:"manifest,omitempty"`
+	// Files maps repository paths to the sha256 of their content.
+	Files map[string]string `json:"files"`
+	// Scripts lists the scripts that passed verification.
+	Scripts    []string  `json:"scripts"`
+	VerifiedAt time.Time `json:"verifiedAt"`
+}
+
+// ManifestOf returns the path of the manifest of script.
+func (e *Entry) ManifestOf(script string) string {
+	if m, ok := e.Manifests[script]; ok {
+		return m
+	}
+	return e.Manifest
+}
+
+// Find returns the verified script called name, given by its path or its
+// file name, and false when no single script matches. Without a name the
+// entry must hold a single script.
+func (e *Entry) Find(name string) (string, bool) {
+	if name == "" {
+		if len(e.Scripts) == 1 {
+			return e.Scripts[0], true
+		}
+		return "", false
+	}
+	var matches []string
+	for _, s := range e.Scripts {
+		if s == path.Clean(name) {
+			return s, true
+		}
+		if path.Base(s) == name {
+			matches = append(matches, s)
+		}
+	}
+	if len(matches) != 1 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+// Dir returns the default cache directory, $XDG_CACHE_HOME/sap.
+func Dir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sap"), nil
+}
+
+// Open returns the cache in dir, creating it if needed.
+func Open(dir string) (*Cache, error) {
+	for _, sub := range []string{"objects", "entries"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Lookup returns the entry of repo at commit, or nil when there is none.
+func (c *Cache) Lookup(repo, commit string) (*Entry, error) {
+	e, err := readEntry(c.entryPath(repo, commit))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return e, err
+}
+
+// Read returns the cached content of repoPath, after checking it still has
+// the hash it was stored under.
+func (c *Cache) Read(e *Entry, repoPath string) ([]byte, error) {
+	sum, ok := e.Files[repoPath]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", repoPath, ErrNotCached)
+	}
+	b, err := os.ReadFile(c.objectPath(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", repoPath, ErrNotCached)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if digest(b) != sum {
+		return nil, fmt.Errorf("cached %s is corrupt, run sap cache prune", repoPath)
+	}
+	return b, nil
+}
+
+// Store adds files, keyed by repository path, and the scripts verified
+// with them to the entry of e.Repo at e.Commit. The manifests of scripts
+// already in the entry are kept.
+func (c *Cache) Store(e *Entry, files map[string][]byte) error {
+	existing, err := c.Lookup(e.Repo, e.Commit)
+	if err != nil {
+		return err
+	}
+	merged := *e
+	merged.Files = map[string]string{}
+	merged.Manifests = map[string]string{}
+	merged.Manifest = ""
+	if existing != nil {
+		for p, sum := range existing.Files {
+			merged.Files[p] = sum
+		}
+		for _, script := range existing.Scripts {
+			merged.Manifests[script] = existing.ManifestOf(script)
+		}
+		merged.Scripts = union(existing.Scripts, e.Scripts)
+	}
+	for _, script := range e.Scripts {
+		merged.Manifests[script] = e.ManifestOf(script)
+	}
+	for p, b := range files {
+		sum := digest(b)
+		if err := writeFile(c.objectPath(sum), b); err != nil {
+			return err
+		}
+		merged.Files[p] = sum
+	}
+	b, err := json.MarshalIndent(&merged, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(c.entryPath(e.Repo, e.Commit), b)
+}
+
+// Entries lists every entry, oldest first.
+func (c *Cache) Entries() ([]*Entry, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "entries", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, f := range files {
+		e, err := readEntry(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].VerifiedAt.Before(entries[j].VerifiedAt) })
+	return entries, nil
+}
+
+// Prune removes the entries drop selects, then every object no remaining
+// entry refers to and every object whose content no longer matches its
+// name.
+func (c *Cache) Prune(drop func(*Entry) bool) (entries int, objects int, err error) {
+	all, err := c.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	used := map[string]bool{}
+	for _, e := range all {
+		if drop(e) {
+			if err := os.Remove(c.entryPath(e.Repo, e.Commit)); err != nil {
+				return entries, objects, err
+			}
+			entries++
+			continue
+		}
+		for _, sum := range e.Files {
+			used[sum] = true
+		}
+	}
+	sums, err := c.objects()
+	if err != nil {
+		return entries, objects, err
+	}
+	for _, sum := range sums {
+		if used[sum] && c.checkObject(sum) == nil {
+			continue
+		}
+		if err := os.Remove(c.objectPath(sum)); err != nil {
+			return entries, objects, err
+		}
+		objects++
+	}
+	return entries, objects, nil
+}
+
+// Check re-hashes every object and makes sure every entry's files are
+// present. It returns one error per problem found.
+func (c *Cache) Check() ([]error, error) {
+	var problems []error
+	sums, err := c.objects()
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, sum := range sums {
+		if err := c.checkObject(sum); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		present[sum] = true
+	}
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		for p, sum := range e.Files {
+			if !present[sum] {
+				problems = append(problems, fmt.Errorf("%s@%s: %s is missing or corrupt", e.Repo, e.Commit, p))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkObject makes sure the object's content hashes to its name.
+func (c *Cache) checkObject(sum string) error {
+	b, err := os.ReadFile(c.objectPath(sum))
+	if err != nil {
+		return err
+	}
+	if digest(b) != sum {
+		return fmt.Errorf("object %s is corrupt", sum)
+	}
+	return nil
+}
+
+// objects lists the sha256 of every stored object.
+func (c *Cache) objects() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "objects", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	var sums []string
+	for _, f := range files {
+		sums = append(sums, filepath.Base(filepath.Dir(f))+filepath.Base(f))
+	}
+	return sums, nil
+}
+
+func (c *Cache) objectPath(sum string) string {
+	if len(sum) < 3 {
+		return filepath.Join(c.dir, "objects", "_", sum)
+	}
+	return filepath.Join(c.dir, "objects", sum[:2], sum[2:])
+}
+
+func (c *Cache) entryPath(repo, commit string) string {
+	return filepath.Join(c.dir, "entries", digest([]byte(repo+"@"+commit))+".json")
+}
+
+func readEntry(file string) (*Entry, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// writeFile replaces file atomically so readers never see partial content.
+func writeFile(file string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
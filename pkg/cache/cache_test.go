@@ -0,0 +1,77 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+)
+
+func TestStoreKeepsManifestPerScript(t *testing.T) {
+	c, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range []string{"scripts/a.sh", "scripts/b.sh"} {
+		manifestPath := ".sap/" + script + "/sap-manifest.json"
+		if err := c.Store(&Entry{
+			Repo:      "github.com/jdoe/repo",
+			Commit:    "abc",
+			Manifests: map[string]string{script: manifestPath},
+			Scripts:   []string{script},
+		}, map[string][]byte{script: []byte(script), manifestPath: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := c.Lookup("github.com/jdoe/repo", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range []string{"scripts/a.sh", "scripts/b.sh"} {
+		if got, want := e.ManifestOf(script), ".sap/"+script+"/sap-manifest.json"; got != want {
+			t.Errorf("ManifestOf(%s) = %s, want %s", script, got, want)
+		}
+		if _, err := c.Read(e, script); err != nil {
+			t.Errorf("Read(%s): %v", script, err)
+		}
+	}
+}
+
+func TestEntryFind(t *testing.T) {
+	e := &Entry{Scripts: []string{"a/run.sh", "b/run.sh", "b/setup.sh"}}
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"a/run.sh", "a/run.sh", true},
+		{"setup.sh", "b/setup.sh", true},
+		{"run.sh", "", false},
+		{"", "", false},
+		{"missing.sh", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := e.Find(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Find(%q) = %q, %t, want %q, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	legacy := &Entry{Manifest: ".sigstore/sap-manifest.json", Scripts: []string{"run.sh"}}
+	if got, ok := legacy.Find(""); !ok || legacy.ManifestOf(got) != legacy.Manifest {
+		t.Errorf("legacy entry: Find(\"\") = %q, %t, manifest %q", got, ok, legacy.ManifestOf(got))
+	}
+}
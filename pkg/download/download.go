@@ -0,0 +1,212 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package download fetches files with bounded size and time and checks
+// them before they are used.
+package download
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits bound every download.
+type Limits struct {
+	// MaxSize is the largest accepted body in bytes.
+	MaxSize int64
+	// Timeout applies to each attempt.
+	Timeout time.Duration
+	// Retries is the number of attempts after the first one.
+	Retries int
+	// Backoff is the wait before the first retry, it doubles with each one.
+	Backoff time.Duration
+}
+
+// DefaultLimits are used by Fetch.
+var DefaultLimits = Limits{
+	MaxSize: 10 << 20,
+	Timeout: 30 * time.Second,
+	Retries: 3,
+	Backoff: 500 * time.Millisecond,
+}
+
+// Digests are the expected digests of a file, empty ones are not checked.
+type Digests struct {
+	// BlobSHA is the git blob id of the file.
+	BlobSHA string
+	// SHA256 is the hex sha256 of the file.
+	SHA256 string
+}
+
+// Doer sends a request, *http.Client implements it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// StatusError is a non 2xx response.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %s", e.URL, e.Status)
+}
+
+// Fetch GETs url with header and returns the body once it passed the
+// DefaultLimits and matches want. Network errors, 429 and 5xx responses are
+// retried.
+func Fetch(ctx context.Context, doer Doer, url string, header http.Header, want Digests) ([]byte, error) {
+	limits := DefaultLimits
+	backoff := limits.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		var body []byte
+		body, err = fetchOnce(ctx, doer, url, header, limits)
+		if err == nil {
+			if err := Check(body, want); err != nil {
+				return nil, fmt.Errorf("%s: %w", url, err)
+			}
+			return body, nil
+		}
+		if attempt >= limits.Retries || !retryable(err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetchOnce makes a single bounded attempt.
+func fetchOnce(ctx context.Context, doer Doer, url string, header http.Header, limits Limits) ([]byte, error) {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if err := CheckSize(resp.ContentLength, limits.MaxSize); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limits.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if limits.MaxSize > 0 && int64(len(body)) > limits.MaxSize {
+		return nil, fmt.Errorf("%s: %w of %d bytes", url, errTooLarge, limits.MaxSize)
+	}
+	return body, nil
+}
+
+// errTooLarge is not worth retrying.
+var errTooLarge = errors.New("file is larger than the download limit")
+
+// CheckSize fails when size exceeds max, a max of zero or less means no
+// limit. It is used to refuse files before downloading them.
+func CheckSize(size int64, max int64) error {
+	if max > 0 && size > max {
+		return fmt.Errorf("%w of %d bytes (%d bytes)", errTooLarge, max, size)
+	}
+	return nil
+}
+
+// retryable reports whether a failed attempt may succeed when repeated.
+func retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500
+	}
+	return !errors.Is(err, errTooLarge) && !errors.Is(err, context.Canceled)
+}
+
+// Check compares content with the expected digests. A 64 character blob id
+// comes from a SHA-256 repository and is checked as such.
+func Check(content []byte, want Digests) error {
+	if want.BlobSHA != "" {
+		blob := BlobSHA(content)
+		if len(want.BlobSHA) == 2*sha256.Size {
+			blob = BlobSHA256(content)
+		}
+		if !strings.EqualFold(blob, want.BlobSHA) {
+			return fmt.Errorf("content does not match git blob %s", want.BlobSHA)
+		}
+	}
+	if want.SHA256 != "" {
+		sum := sha256.Sum256(content)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), want.SHA256) {
+			return fmt.Errorf("content does not match sha256 %s", want.SHA256)
+		}
+	}
+	return nil
+}
+
+// BlobSHA returns the git blob id of content in a SHA-1 repository.
+func BlobSHA(content []byte) string {
+	return blobID(sha1.New(), content)
+}
+
+// BlobSHA256 returns the git blob id of content in a SHA-256 repository.
+func BlobSHA256(content []byte) string {
+	return blobID(sha256.New(), content)
+}
+
+func blobID(h hash.Hash, content []byte) string {
+	h.Write([]byte("blob " + strconv.Itoa(len(content)) + "\x00"))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteFile stores checked content in dest, which must not exist yet.
+func WriteFile(dest string, content []byte) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
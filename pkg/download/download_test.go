@@ -0,0 +1,194 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLimits replaces the DefaultLimits for the duration of the test.
+func testLimits(t *testing.T, l Limits) {
+	t.Helper()
+	saved := DefaultLimits
+	DefaultLimits = l
+	t.Cleanup(func() { DefaultLimits = saved })
+}
+
+// flakyServer fails the first failures requests with status, then serves
+// body. It returns the server and the number of requests it saw.
+func flakyServer(t *testing.T, failures int32, status int, body []byte) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if atomic.AddInt32(&requests, 1) <= failures {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetchRetries(t *testing.T) {
+	testLimits(t, Limits{MaxSize: 1 << 10, Timeout: time.Second, Retries: 2, Backoff: time.Millisecond})
+	body := []byte("#!/bin/sh\necho hi\n")
+	header := http.Header{"Authorization": {"token secret"}}
+
+	tests := []struct {
+		name     string
+		failures int32
+		status   int
+		requests int32
+		err      bool
+	}{
+		{name: "first attempt", requests: 1},
+		{name: "too many requests", failures: 2, status: http.StatusTooManyRequests, requests: 3},
+		{name: "server error", failures: 1, status: http.StatusBadGateway, requests: 2},
+		{name: "gives up after the last retry", failures: 3, status: http.StatusServiceUnavailable, requests: 3, err: true},
+		{name: "not found is final", failures: 1, status: http.StatusNotFound, requests: 1, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := flakyServer(t, tt.failures, tt.status, body)
+			got, err := Fetch(context.Background(), srv.Client(), srv.URL, header, Digests{SHA256: sha256Hex(body)})
+			if tt.err {
+				if err == nil {
+					t.Error("Fetch succeeded")
+				}
+			} else if err != nil {
+				t.Error(err)
+			} else if string(got) != string(body) {
+				t.Errorf("Fetch = %q, want %q", got, body)
+			}
+			if n := atomic.LoadInt32(requests); n != tt.requests {
+				t.Errorf("Fetch made %d requests, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFetchSizeCap(t *testing.T) {
+	testLimits(t, Limits{MaxSize: 16, Timeout: time.Second, Retries: 2, Backoff: time.Millisecond})
+	header := http.Header{"Authorization": {"token secret"}}
+	srv, requests := flakyServer(t, 0, 0, []byte(strings.Repeat("x", 17)))
+	if _, err := Fetch(context.Background(), srv.Client(), srv.URL, header, Digests{}); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Fetch of an oversized file = %v, want a size error", err)
+	}
+	// Oversized files are not retried
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("Fetch made %d requests, want 1", n)
+	}
+
+	// Without a Content-Length the body is cut off at the limit
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 10)))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer chunked.Close()
+	if _, err := Fetch(context.Background(), chunked.Client(), chunked.URL, nil, Digests{}); err == nil {
+		t.Error("Fetch of an oversized streamed file succeeded")
+	}
+}
+
+func TestFetchDigestMismatch(t *testing.T) {
+	testLimits(t, Limits{MaxSize: 1 << 10, Timeout: time.Second, Retries: 2, Backoff: time.Millisecond})
+	body := []byte("echo hi\n")
+	header := http.Header{"Authorization": {"token secret"}}
+	srv, requests := flakyServer(t, 0, 0, body)
+
+	for _, want := range []Digests{
+		{SHA256: sha256Hex([]byte("echo evil\n"))},
+		{BlobSHA: BlobSHA([]byte("echo evil\n"))},
+		{BlobSHA: BlobSHA256([]byte("echo evil\n"))},
+	} {
+		if _, err := Fetch(context.Background(), srv.Client(), srv.URL, header, want); err == nil {
+			t.Errorf("Fetch with digests %+v succeeded", want)
+		}
+	}
+	// A mismatch is final, each Fetch made one request
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("Fetch made %d requests, want 3", n)
+	}
+	if _, err := Fetch(context.Background(), srv.Client(), srv.URL, header, Digests{BlobSHA: BlobSHA(body), SHA256: sha256Hex(body)}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlobSHA(t *testing.T) {
+	// git hash-object, with --object-format=sha256 for the 64 character ids
+	tests := []struct {
+		content string
+		sha1    string
+		sha256  string
+	}{
+		{"", "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391", "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"},
+		{"hi\n", "45b983be36b73c0788dc9cbcb76cbb80fc7bb057", "96c18f0297e38d01f4b2dacddea4259aea6b2961eb0822bd2c0c3f6029030045"},
+	}
+	for _, tt := range tests {
+		if got := BlobSHA([]byte(tt.content)); got != tt.sha1 {
+			t.Errorf("BlobSHA(%q) = %s, want %s", tt.content, got, tt.sha1)
+		}
+		if got := BlobSHA256([]byte(tt.content)); got != tt.sha256 {
+			t.Errorf("BlobSHA256(%q) = %s, want %s", tt.content, got, tt.sha256)
+		}
+		for _, id := range []string{tt.sha1, tt.sha256, strings.ToUpper(tt.sha256)} {
+			if err := Check([]byte(tt.content), Digests{BlobSHA: id}); err != nil {
+				t.Errorf("Check(%q, %s): %v", tt.content, id, err)
+			}
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "script")
+	if err := WriteFile(dest, []byte("echo hi\n")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("WriteFile created the file with mode %v", info.Mode().Perm())
+	}
+	if err := WriteFile(dest, []byte("echo evil\n")); err == nil {
+		t.Error("WriteFile over an existing file succeeded")
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "echo hi\n" {
+		t.Errorf("the file holds %q", got)
+	}
+}
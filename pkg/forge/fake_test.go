@@ -0,0 +1,281 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRepo is the repository behind the GitLab and Gitea stand-ins, which
+// only translate their API to it. Every commit keeps a full snapshot of the
+// files.
+type fakeRepo struct {
+	mu       sync.Mutex
+	commits  map[string]*fakeCommit
+	branches map[string]string
+	tags     map[string]string
+	// releases lists the released tags, newest first
+	releases []string
+	// blobID, when set, replaces the blob id reported for every file
+	blobID string
+	// mergeRequests lists the merge requests, oldest first
+	mergeRequests []*fakeMergeRequest
+	// assets holds the files attached to each release by name
+	assets map[string]map[string][]byte
+}
+
+type fakeMergeRequest struct {
+	branch, base string
+	// state is open, merged or closed
+	state    string
+	head     string
+	mergeSHA string
+}
+
+// fakeEntry is a tree entry, a blob or a tree.
+type fakeEntry struct {
+	path, typ string
+}
+
+type fakeCommit struct {
+	parent string
+	files  map[string]fakeFile
+}
+
+type fakeFile struct {
+	content    []byte
+	executable bool
+}
+
+// newFakeRepo returns a repository whose main branch has a single commit
+// holding README.md, released as v1.
+func newFakeRepo() *fakeRepo {
+	r := &fakeRepo{
+		commits:  map[string]*fakeCommit{},
+		branches: map[string]string{},
+		tags:     map[string]string{},
+		assets:   map[string]map[string][]byte{},
+	}
+	sha := r.commit("", map[string]fakeFile{"README.md": {content: []byte("# repo\n")}})
+	r.branches["main"] = sha
+	r.tags["v1"] = sha
+	r.releases = []string{"v1"}
+	return r
+}
+
+// commit records the changes on top of parent and returns the new commit
+// SHA. The caller holds r.mu.
+func (r *fakeRepo) commit(parent string, changes map[string]fakeFile) string {
+	files := map[string]fakeFile{}
+	if p, ok := r.commits[parent]; ok {
+		for path, f := range p.files {
+			files[path] = f
+		}
+	}
+	for path, f := range changes {
+		files[path] = f
+	}
+	sha := fmt.Sprintf("%x", sha1.Sum([]byte(strconv.Itoa(len(r.commits)))))
+	r.commits[sha] = &fakeCommit{parent: parent, files: files}
+	return sha
+}
+
+// resolve returns the commit a branch, tag or possibly abbreviated SHA
+// names. The caller holds r.mu.
+func (r *fakeRepo) resolve(rev string) (string, bool) {
+	if sha, ok := r.branches[rev]; ok {
+		return sha, true
+	}
+	if sha, ok := r.tags[rev]; ok {
+		return sha, true
+	}
+	match := ""
+	for sha := range r.commits {
+		if len(rev) >= 7 && strings.HasPrefix(sha, rev) {
+			if match != "" {
+				return "", false
+			}
+			match = sha
+		}
+	}
+	return match, match != ""
+}
+
+// lookup returns the file at path as of rev. The caller holds r.mu.
+func (r *fakeRepo) lookup(rev string, path string) (fakeFile, bool) {
+	sha, ok := r.resolve(rev)
+	if !ok {
+		return fakeFile{}, false
+	}
+	f, ok := r.commits[sha].files[path]
+	return f, ok
+}
+
+// tree lists the files and directories of the commit, sorted by path. The
+// caller holds r.mu.
+func (r *fakeRepo) tree(sha string) []fakeEntry {
+	var entries []fakeEntry
+	dirs := map[string]bool{}
+	for file := range r.commits[sha].files {
+		entries = append(entries, fakeEntry{path: file, typ: "blob"})
+		for dir := path.Dir(file); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			entries = append(entries, fakeEntry{path: dir, typ: "tree"})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+	return entries
+}
+
+// page returns page (counting from 1) of entries with at most perPage
+// entries, which the server caps at max, and whether more pages follow.
+func page(entries []fakeEntry, perPage string, page string, max int) ([]fakeEntry, bool) {
+	n, err := strconv.Atoi(perPage)
+	if err != nil || n > max {
+		n = max
+	}
+	p, err := strconv.Atoi(page)
+	if err != nil || p < 1 {
+		p = 1
+	}
+	start := (p - 1) * n
+	if start >= len(entries) {
+		return nil, false
+	}
+	end := start + n
+	if end >= len(entries) {
+		return entries[start:], false
+	}
+	return entries[start:end], true
+}
+
+// mergeRequest returns the newest merge request from branch into base, or
+// nil. The caller holds r.mu.
+func (r *fakeRepo) mergeRequest(branch string, base string) *fakeMergeRequest {
+	for i := len(r.mergeRequests) - 1; i >= 0; i-- {
+		if mr := r.mergeRequests[i]; mr.branch == branch && mr.base == base {
+			return mr
+		}
+	}
+	return nil
+}
+
+// createRelease tags the commit ref names, unless the tag was released
+// already. The caller holds r.mu.
+func (r *fakeRepo) createRelease(tag string, ref string) bool {
+	sha, ok := r.resolve(ref)
+	if !ok {
+		return false
+	}
+	for _, released := range r.releases {
+		if released == tag {
+			return false
+		}
+	}
+	r.tags[tag] = sha
+	r.releases = append([]string{tag}, r.releases...)
+	r.assets[tag] = map[string][]byte{}
+	return true
+}
+
+// blobSHA returns the blob id reported for f. The caller holds r.mu.
+func (r *fakeRepo) blobSHA(f fakeFile) string {
+	if r.blobID != "" {
+		return r.blobID
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(f.content))
+	h.Write(f.content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *fakeRepo) branch(t *testing.T, name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.branches[name]
+}
+
+func (r *fakeRepo) file(t *testing.T, sha string, path string) ([]byte, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.lookup(sha, path)
+	if f.executable {
+		return f.content, "100755", ok
+	}
+	return f.content, "100644", ok
+}
+
+func (r *fakeRepo) release(t *testing.T, tag string, sha string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[tag] = sha
+	r.releases = append([]string{tag}, r.releases...)
+}
+
+func (r *fakeRepo) merge(t *testing.T, branch string, base string) string {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mr := r.mergeRequest(branch, base)
+	if mr == nil || mr.state != "open" {
+		t.Fatalf("no open merge request from %s into %s", branch, base)
+	}
+	mr.head = r.branches[branch]
+	mr.mergeSHA = r.commit(r.branches[base], r.commits[mr.head].files)
+	mr.state = "merged"
+	r.branches[base] = mr.mergeSHA
+	return mr.mergeSHA
+}
+
+// closeMergeRequest closes the merge request from branch into base without
+// merging it.
+func (r *fakeRepo) closeMergeRequest(t *testing.T, branch string, base string) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mr := r.mergeRequest(branch, base)
+	if mr == nil {
+		t.Fatalf("no merge request from %s into %s", branch, base)
+	}
+	mr.state = "closed"
+}
+
+// readForm returns the content of the multipart form file field of r.
+func readForm(r *http.Request, field string) ([]byte, string, error) {
+	f, header, err := r.FormFile(field)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	return content, header.Filename, err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
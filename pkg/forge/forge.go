@@ -0,0 +1,202 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forge abstracts the git hosting service signed materials are
+// committed to by sign and read back from by install.
+package forge
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Forge is a git repository on a hosting service (or the local filesystem)
+// holding signed scripts and their materials.
+type Forge interface {
+	// CommitFiles commits the files to the branch of c, creating the branch
+	// from the base branch when needed, and returns the new commit SHA.
+	CommitFiles(ctx context.Context, c Commit) (sha string, err error)
+	// CreateMergeRequest proposes merging a branch holding new materials.
+	CreateMergeRequest(ctx context.Context, mr MergeRequest) error
+	// LatestRelease returns the tag of the latest release.
+	LatestRelease(ctx context.Context) (tag string, err error)
+	// ResolveCommit resolves a commit SHA, a ref (branch or tag) or a
+	// release tag to a full commit SHA, in that order of precedence. The
+	// tag "latest" resolves to the latest release.
+	ResolveCommit(ctx context.Context, tag string, ref string, commit string) (sha string, err error)
+	// ListFiles lists the paths of all files below dir in the tree of the
+	// commit, or of the whole tree when dir is empty. A missing dir has no
+	// files.
+	ListFiles(ctx context.Context, sha string, dir string) ([]string, error)
+	// Download writes the file at path as of the commit to dest, which must
+	// not exist yet. The content is checked against the git blob id the
+	// forge reports and, when not empty, against sha256 before it is written.
+	Download(ctx context.Context, sha string, path string, sha256 string, dest string) error
+	// MergedCommit returns the commit that merged the merge request of
+	// mr.Branch into mr.Base, or an empty SHA while it is still open.
+	MergedCommit(ctx context.Context, mr MergeRequest) (sha string, err error)
+	// CreateRelease tags the commit of r, publishes the release with its
+	// assets and returns its URL.
+	CreateRelease(ctx context.Context, r Release) (url string, err error)
+}
+
+// Commit describes a commit of local files to a branch.
+type Commit struct {
+	Branch      string
+	BaseBranch  string
+	AuthorName  string
+	AuthorEmail string
+	Message     string
+	// Files are the files to commit, each either a local path that is also
+	// used in the repository or "local:target".
+	Files []string
+}
+
+// MergeRequest describes a request to merge Branch into Base.
+type MergeRequest struct {
+	// Owner and Repo of the repository to open the request against, empty
+	// for the repository the branch lives in.
+	Owner string
+	Repo  string
+	// HeadOwner owns the fork the branch was pushed to, empty when the
+	// branch lives in the repository itself. Only MergedCommit uses it.
+	HeadOwner string
+	Branch    string
+	Base      string
+	Title     string
+	Body      string
+}
+
+// Release describes a release of a commit.
+type Release struct {
+	Tag    string
+	Commit string
+	Name   string
+	Body   string
+	// Assets are local files to attach to the release.
+	Assets []string
+}
+
+// Supported forge kinds.
+const (
+	KindGitHub = "github"
+	KindGitLab = "gitlab"
+	KindGitea  = "gitea"
+	KindLocal  = "local"
+)
+
+// Location identifies a repository on a forge.
+type Location struct {
+	Kind string
+	// BaseURL is the web or API root of a self hosted forge, for example
+	// https://gitlab.example.com. Empty for the public default.
+	BaseURL string
+	Owner   string
+	Repo    string
+	// Dir is the path of a local repository.
+	Dir string
+}
+
+// ID identifies the repository independently of how it was given, for
+// example github.com/owner/repo.
+func (l Location) ID() string {
+	if l.Kind == KindLocal {
+		dir, err := filepath.Abs(l.Dir)
+		if err != nil {
+			dir = l.Dir
+		}
+		return "file://" + filepath.ToSlash(dir)
+	}
+	host := l.BaseURL
+	switch {
+	case host == "" && l.Kind == KindGitHub:
+		host = "github.com"
+	case host == "" && l.Kind == KindGitLab:
+		host = DefaultGitLabURL
+	}
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		host = u.Host + strings.TrimSuffix(u.Path, "/")
+	}
+	return host + "/" + l.Owner + "/" + l.Repo
+}
+
+// ParseURL parses a repository URL whose scheme selects the forge:
+//
+//	github://owner/repo
+//	gitlab://gitlab.example.com/group/subgroup/repo
+//	gitea://gitea.example.com/owner/repo
+//	file:///path/to/repo.git
+//
+// GitLab and Gitea are reached over https, use gitlab+http:// or gitea+http://
+// for plain http.
+func ParseURL(raw string) (Location, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Location{}, err
+	}
+	scheme := "https"
+	kind := u.Scheme
+	if i := strings.Index(kind, "+"); i >= 0 {
+		kind, scheme = kind[:i], kind[i+1:]
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch kind {
+	case "file":
+		return Location{Kind: KindLocal, Dir: u.Path}, nil
+	case KindGitHub:
+		// github://owner/repo puts the owner in the host position
+		parts = append([]string{u.Host}, parts...)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return Location{}, fmt.Errorf("expected github://owner/repo, got %s", raw)
+		}
+		return Location{Kind: KindGitHub, Owner: parts[0], Repo: parts[1]}, nil
+	case KindGitLab, KindGitea:
+		if u.Host == "" || len(parts) < 2 || parts[len(parts)-1] == "" {
+			return Location{}, fmt.Errorf("expected %s://host/owner/repo, got %s", kind, raw)
+		}
+		return Location{
+			Kind:    kind,
+			BaseURL: scheme + "://" + u.Host,
+			Owner:   strings.Join(parts[:len(parts)-1], "/"),
+			Repo:    parts[len(parts)-1],
+		}, nil
+	}
+	return Location{}, fmt.Errorf("unsupported repository URL scheme %q", u.Scheme)
+}
+
+// splitProject splits an owner/repo path, where owner may contain slashes.
+func splitProject(project string) (owner string, repo string) {
+	i := strings.LastIndex(project, "/")
+	return project[:i], project[i+1:]
+}
+
+// splitFile splits a "local:target" file argument.
+func splitFile(file string) (local string, target string) {
+	if i := strings.Index(file, ":"); i >= 0 {
+		return file[:i], file[i+1:]
+	}
+	return file, filepath.ToSlash(file)
+}
+
+// inDir reports whether the repository path p is below dir, any path being
+// below the empty dir.
+func inDir(p string, dir string) bool {
+	dir = strings.Trim(dir, "/")
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
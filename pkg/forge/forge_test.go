@@ -0,0 +1,498 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testForge is a forge under test along with the repository behind it. The
+// repository starts out with README.md on main and v1 released from there.
+type testForge struct {
+	name  string
+	forge Forge
+	repo  testRepo
+}
+
+// testRepo looks at and changes the repository behind a forge directly.
+type testRepo interface {
+	// branch returns the commit the branch points to, or "" when it does
+	// not exist.
+	branch(t *testing.T, name string) string
+	// file returns the content and git mode of path at the commit.
+	file(t *testing.T, sha string, path string) (content []byte, mode string, ok bool)
+	// release tags the commit as the latest release.
+	release(t *testing.T, tag string, sha string)
+	// merge merges the open merge request from branch into base and
+	// returns the merge commit.
+	merge(t *testing.T, branch string, base string) string
+}
+
+// testForges returns a fresh repository for each forge implementation.
+func testForges(t *testing.T) []testForge {
+	t.Helper()
+	forges := []testForge{newGitLabForge(t), newGiteaForge(t)}
+	if _, err := exec.LookPath("git"); err == nil {
+		forges = append(forges, newLocalForge(t))
+	}
+	return forges
+}
+
+// localFile writes content to name in dir with mode and returns the
+// forge.Commit file entry committing it to target.
+func localFile(t *testing.T, dir string, name string, content []byte, mode os.FileMode, target string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, content, mode); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile is subject to the umask
+	if err := os.Chmod(file, mode); err != nil {
+		t.Fatal(err)
+	}
+	return file + ":" + target
+}
+
+// binaryContent holds every byte value, including NUL and invalid UTF-8.
+func binaryContent() []byte {
+	b := make([]byte, 512)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+// commitFile commits a single file to branch, which must exist.
+func commitFile(t *testing.T, f Forge, branch string, target string, content []byte) string {
+	t.Helper()
+	sha, err := f.CommitFiles(context.Background(), Commit{
+		Branch:      branch,
+		AuthorName:  "sap",
+		AuthorEmail: "sap@example.com",
+		Message:     "add " + target,
+		Files:       []string{localFile(t, t.TempDir(), "file", content, 0644, target)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Location
+		err  bool
+	}{
+		{raw: "github://jdoe/repo", want: Location{Kind: KindGitHub, Owner: "jdoe", Repo: "repo"}},
+		{raw: "github://jdoe", err: true},
+		{raw: "github://jdoe/repo/extra", err: true},
+		{
+			raw:  "gitlab://gitlab.example.com/group/subgroup/repo",
+			want: Location{Kind: KindGitLab, BaseURL: "https://gitlab.example.com", Owner: "group/subgroup", Repo: "repo"},
+		},
+		{
+			raw:  "gitlab+http://localhost:8080/group/repo",
+			want: Location{Kind: KindGitLab, BaseURL: "http://localhost:8080", Owner: "group", Repo: "repo"},
+		},
+		{
+			raw:  "gitea://gitea.example.com/jdoe/repo",
+			want: Location{Kind: KindGitea, BaseURL: "https://gitea.example.com", Owner: "jdoe", Repo: "repo"},
+		},
+		{raw: "gitea://gitea.example.com/repo", err: true},
+		{raw: "file:///srv/git/repo.git", want: Location{Kind: KindLocal, Dir: "/srv/git/repo.git"}},
+		{raw: "svn://example.com/repo", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseURL(tt.raw)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseURL(%s) = %+v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseURL(%s): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseURL(%s) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	tests := []struct {
+		file, local, target string
+	}{
+		{"run.sh", "run.sh", "run.sh"},
+		{filepath.Join("scripts", "run.sh"), filepath.Join("scripts", "run.sh"), "scripts/run.sh"},
+		{"/tmp/manifest:.sap/run.sh/sap-manifest.json", "/tmp/manifest", ".sap/run.sh/sap-manifest.json"},
+	}
+	for _, tt := range tests {
+		local, target := splitFile(tt.file)
+		if local != tt.local || target != tt.target {
+			t.Errorf("splitFile(%s) = %s, %s, want %s, %s", tt.file, local, target, tt.local, tt.target)
+		}
+	}
+}
+
+func TestCommitFiles(t *testing.T) {
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			main := f.repo.branch(t, "main")
+			dir := t.TempDir()
+			c := Commit{
+				Branch:      "sap",
+				BaseBranch:  "main",
+				AuthorName:  "sap",
+				AuthorEmail: "sap@example.com",
+				Message:     "sign",
+				Files: []string{
+					localFile(t, dir, "manifest", []byte("{}\n"), 0644, ".sap/run.sh/sap-manifest.json"),
+					localFile(t, dir, "readme", []byte("# signed\n"), 0644, "README.md"),
+				},
+			}
+			sha, err := f.forge.CommitFiles(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head := f.repo.branch(t, "sap"); head != sha {
+				t.Errorf("CommitFiles returned %s, the branch points to %s", sha, head)
+			}
+			for target, want := range map[string]string{".sap/run.sh/sap-manifest.json": "{}\n", "README.md": "# signed\n"} {
+				if got, _, _ := f.repo.file(t, sha, target); string(got) != want {
+					t.Errorf("%s holds %q, want %q", target, got, want)
+				}
+			}
+			// The base branch is left alone
+			if head := f.repo.branch(t, "main"); head != main {
+				t.Errorf("main moved from %s to %s", main, head)
+			}
+
+			// Later commits go on top of the branch
+			c.Files = []string{localFile(t, dir, "again", []byte("# again\n"), 0644, "README.md")}
+			again, err := f.forge.CommitFiles(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _, _ := f.repo.file(t, again, "README.md"); string(got) != "# again\n" {
+				t.Errorf("README.md holds %q after the second commit", got)
+			}
+			if _, _, ok := f.repo.file(t, again, ".sap/run.sh/sap-manifest.json"); !ok {
+				t.Error("the second commit dropped the manifest")
+			}
+
+			for _, c := range []Commit{{Branch: "other", BaseBranch: "other"}, {Branch: "other"}, {Branch: "other", BaseBranch: "missing"}} {
+				if _, err := f.forge.CommitFiles(ctx, c); err == nil {
+					t.Errorf("CommitFiles to a missing branch from %q succeeded", c.BaseBranch)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitFilesKeepsBytesAndModes(t *testing.T) {
+	for _, f := range append(testForges(t), newGitHubForge(t)) {
+		t.Run(f.name, func(t *testing.T) {
+			files := []struct {
+				target  string
+				content []byte
+				perm    os.FileMode
+				mode    string
+			}{
+				// Text is committed as is, without line ending conversion
+				{"scripts/run.sh", []byte("#!/bin/sh\r\necho run\r\n"), 0755, "100755"},
+				{"bin/tool", binaryContent(), 0644, "100644"},
+			}
+			dir := t.TempDir()
+			c := Commit{
+				Branch:      "sap",
+				BaseBranch:  "main",
+				AuthorName:  "sap",
+				AuthorEmail: "sap@example.com",
+				Message:     "sign",
+			}
+			for _, file := range files {
+				c.Files = append(c.Files, localFile(t, dir, filepath.Base(file.target), file.content, file.perm, file.target))
+			}
+			if f.name == "gitea" {
+				// The Gitea contents API has no file modes, executable
+				// files are refused rather than committed as 100644
+				if _, err := f.forge.CommitFiles(context.Background(), c); err == nil {
+					t.Error("CommitFiles of an executable file to Gitea succeeded")
+				}
+				if head := f.repo.branch(t, "sap"); head != "" {
+					t.Errorf("CommitFiles of an executable file created the branch at %s", head)
+				}
+				if err := os.Chmod(filepath.Join(dir, "run.sh"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files[0].mode = "100644"
+			}
+			sha, err := f.forge.CommitFiles(context.Background(), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range files {
+				content, mode, ok := f.repo.file(t, sha, want.target)
+				if !ok {
+					t.Errorf("%s is not in the commit", want.target)
+					continue
+				}
+				if mode != want.mode {
+					t.Errorf("%s has mode %s, want %s", want.target, mode, want.mode)
+				}
+				if string(content) != string(want.content) {
+					t.Errorf("%s was committed as %q, want %q", want.target, content, want.content)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			v1 := f.repo.branch(t, "main")
+			v2 := commitFile(t, f.forge, "main", "CHANGELOG.md", []byte("v2\n"))
+			f.repo.release(t, "v2", v2)
+
+			tests := []struct {
+				tag, ref, commit string
+				want             string
+			}{
+				{commit: v1, ref: "main", want: v1},
+				{commit: v1[:12], want: v1},
+				{ref: "main", tag: "v1", want: v2},
+				{tag: "v1", want: v1},
+				{tag: "latest", want: v2},
+				{want: v2},
+			}
+			for _, tt := range tests {
+				got, err := f.forge.ResolveCommit(ctx, tt.tag, tt.ref, tt.commit)
+				if err != nil {
+					t.Errorf("ResolveCommit(%q, %q, %q): %v", tt.tag, tt.ref, tt.commit, err)
+					continue
+				}
+				if got != tt.want {
+					t.Errorf("ResolveCommit(%q, %q, %q) = %s, want %s", tt.tag, tt.ref, tt.commit, got, tt.want)
+				}
+			}
+			if _, err := f.forge.ResolveCommit(ctx, "v9", "", ""); err == nil {
+				t.Error("ResolveCommit of a missing tag succeeded")
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			main := f.repo.branch(t, "main")
+			content := []byte("#!/bin/sh\necho hi\n")
+			digest := sha256.Sum256(content)
+			sum := hex.EncodeToString(digest[:])
+			sha := commitFile(t, f.forge, "main", "scripts/run.sh", content)
+			dir := t.TempDir()
+
+			dest := filepath.Join(dir, "run.sh")
+			if err := f.forge.Download(ctx, sha, "scripts/run.sh", sum, dest); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(dest); string(got) != string(content) {
+				t.Errorf("downloaded %q, want %q", got, content)
+			}
+			if err := f.forge.Download(ctx, sha, "scripts/run.sh", sum, dest); err == nil {
+				t.Error("Download over an existing file succeeded")
+			}
+			if err := f.forge.Download(ctx, main, "scripts/run.sh", "", filepath.Join(dir, "old")); err == nil {
+				t.Error("Download of a file the commit does not have succeeded")
+			}
+			if err := f.forge.Download(ctx, sha, "scripts/missing.sh", "", filepath.Join(dir, "missing")); err == nil {
+				t.Error("Download of a missing file succeeded")
+			}
+
+			wrong := filepath.Join(dir, "wrong")
+			if err := f.forge.Download(ctx, sha, "scripts/run.sh", strings.Repeat("0", 64), wrong); err == nil {
+				t.Error("Download with the wrong sha256 succeeded")
+			}
+			if _, err := os.Stat(wrong); !os.IsNotExist(err) {
+				t.Error("Download with the wrong sha256 wrote the file")
+			}
+
+			// The content must also match the blob id the forge reports
+			if fake, ok := f.repo.(*fakeRepo); ok {
+				fake.mu.Lock()
+				fake.blobID = strings.Repeat("0", 40)
+				fake.mu.Unlock()
+				tampered := filepath.Join(dir, "tampered")
+				if err := f.forge.Download(ctx, sha, "scripts/run.sh", sum, tampered); err == nil {
+					t.Error("Download of content that does not match the blob id succeeded")
+				}
+				if _, err := os.Stat(tampered); !os.IsNotExist(err) {
+					t.Error("Download of content that does not match the blob id wrote the file")
+				}
+			}
+		})
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			// More files than fit on one page of either API
+			dir := t.TempDir()
+			c := Commit{
+				Branch:      "sap",
+				BaseBranch:  "main",
+				AuthorName:  "sap",
+				AuthorEmail: "sap@example.com",
+				Message:     "sign",
+			}
+			var want []string
+			for i := 0; i < 120; i++ {
+				target := fmt.Sprintf(".sap/scripts/run%03d.sh/sap-manifest.json", i)
+				c.Files = append(c.Files, localFile(t, dir, strconv.Itoa(i), []byte("{}\n"), 0644, target))
+				want = append(want, target)
+			}
+			c.Files = append(c.Files, localFile(t, dir, "other", []byte("other\n"), 0644, "docs/other.md"))
+			sha, err := f.forge.CommitFiles(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := f.forge.ListFiles(ctx, sha, ".sap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ListFiles(.sap) returned %d files, want %d: %q", len(got), len(want), got)
+			}
+
+			all, err := f.forge.ListFiles(ctx, sha, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != len(want)+2 {
+				t.Errorf("ListFiles returned %d files, want %d", len(all), len(want)+2)
+			}
+
+			if got, err := f.forge.ListFiles(ctx, sha, "missing"); err != nil || len(got) != 0 {
+				t.Errorf("ListFiles(missing) = %q, %v, want no files", got, err)
+			}
+		})
+	}
+}
+
+func TestMergedCommit(t *testing.T) {
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			commitBranch := func(branch string) MergeRequest {
+				t.Helper()
+				if _, err := f.forge.CommitFiles(ctx, Commit{
+					Branch:      branch,
+					BaseBranch:  "main",
+					AuthorName:  "sap",
+					AuthorEmail: "sap@example.com",
+					Message:     "sign",
+					Files:       []string{localFile(t, t.TempDir(), "manifest", []byte("{}\n"), 0644, ".sap/"+branch+"/sap-manifest.json")},
+				}); err != nil {
+					t.Fatal(err)
+				}
+				mr := MergeRequest{Branch: branch, Base: "main", Title: "sign"}
+				if err := f.forge.CreateMergeRequest(ctx, mr); err != nil {
+					t.Fatal(err)
+				}
+				return mr
+			}
+
+			mr := commitBranch("sap")
+			if sha, err := f.forge.MergedCommit(ctx, mr); err != nil || sha != "" {
+				t.Errorf("MergedCommit of an open merge request = %q, %v, want pending", sha, err)
+			}
+			merged := f.repo.merge(t, "sap", "main")
+			if sha, err := f.forge.MergedCommit(ctx, mr); err != nil || sha != merged {
+				t.Errorf("MergedCommit = %q, %v, want %s", sha, err, merged)
+			}
+
+			if _, err := f.forge.MergedCommit(ctx, MergeRequest{Branch: "missing", Base: "main"}); err == nil {
+				t.Error("MergedCommit of a branch without a merge request succeeded")
+			}
+			if fake, ok := f.repo.(*fakeRepo); ok {
+				mr := commitBranch("rejected")
+				fake.closeMergeRequest(t, "rejected", "main")
+				if _, err := f.forge.MergedCommit(ctx, mr); err == nil {
+					t.Error("MergedCommit of a closed merge request succeeded")
+				}
+			}
+		})
+	}
+}
+
+func TestCreateRelease(t *testing.T) {
+	// Local releases are annotated tags, which need a committer
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	for _, f := range testForges(t) {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := context.Background()
+			sha := commitFile(t, f.forge, "main", "CHANGELOG.md", []byte("v2\n"))
+			asset := filepath.Join(t.TempDir(), "install.sh.bundle")
+			if err := os.WriteFile(asset, binaryContent(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			r := Release{Tag: "v2", Commit: sha, Name: "v2", Body: "Signed scripts", Assets: []string{asset}}
+			if _, err := f.forge.CreateRelease(ctx, r); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := f.forge.ResolveCommit(ctx, "v2", "", ""); err != nil || got != sha {
+				t.Errorf("ResolveCommit(v2) = %s, %v, want %s", got, err, sha)
+			}
+			if _, err := f.forge.CreateRelease(ctx, r); err == nil {
+				t.Error("CreateRelease of an existing release succeeded")
+			}
+
+			if fake, ok := f.repo.(*fakeRepo); ok {
+				if latest, err := f.forge.LatestRelease(ctx); err != nil || latest != "v2" {
+					t.Errorf("LatestRelease = %s, %v, want v2", latest, err)
+				}
+				fake.mu.Lock()
+				defer fake.mu.Unlock()
+				if got := fake.assets["v2"]["install.sh.bundle"]; string(got) != string(binaryContent()) {
+					t.Errorf("the release asset holds %q", got)
+				}
+			}
+		})
+	}
+}
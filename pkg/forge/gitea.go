@@ -0,0 +1,340 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/utils"
+)
+
+// Gitea stores materials in a repository on a Gitea instance, using the v1
+// REST API.
+type Gitea struct {
+	api   *apiClient
+	owner string
+	repo  string
+}
+
+// NewGitea returns a forge backed by the owner/repo repository on the Gitea
+// instance at baseURL.
+func NewGitea(httpClient *http.Client, baseURL string, token string, owner string, repo string) *Gitea {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	var auth string
+	if token != "" {
+		auth = "token " + token
+	}
+	return &Gitea{
+		api: &apiClient{
+			base:       baseURL + "/api/v1",
+			authHeader: "Authorization",
+			authValue:  auth,
+			http:       httpClient,
+		},
+		owner: owner,
+		repo:  repo,
+	}
+}
+
+// repoPath returns the API path of the repository followed by elem.
+func (g *Gitea) repoPath(elem string) string {
+	return "/repos/" + url.PathEscape(g.owner) + "/" + url.PathEscape(g.repo) + elem
+}
+
+// escapePath escapes each element of a file path.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+type giteaCommit struct {
+	SHA string `json:"sha"`
+}
+
+// CommitFiles implements Forge. All files are committed in a single commit
+// through the change files API.
+func (g *Gitea) CommitFiles(ctx context.Context, c Commit) (string, error) {
+	body := map[string]interface{}{
+		"branch":  c.Branch,
+		"message": c.Message,
+		"author":  map[string]string{"name": c.AuthorName, "email": c.AuthorEmail},
+	}
+
+	ref := c.Branch
+	_, err := g.api.request(ctx, http.MethodGet, g.repoPath("/branches/"+url.PathEscape(c.Branch)), nil, nil, nil)
+	switch {
+	case IsNotFound(err):
+		if c.Branch == c.BaseBranch {
+			return "", errors.New("the commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+		}
+		if c.BaseBranch == "" {
+			return "", errors.New("the `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+		}
+		body["branch"] = c.BaseBranch
+		body["new_branch"] = c.Branch
+		ref = c.BaseBranch
+	case err != nil:
+		return "", err
+	}
+
+	var files []map[string]string
+	for _, file := range c.Files {
+		target, content, err := readLocal(file)
+		if err != nil {
+			return "", err
+		}
+		// The contents API has no file modes, every file becomes 100644.
+		// Refuse to silently commit a script that would no longer run
+		local, _ := splitFile(file)
+		mode, err := utils.GitFileMode(local)
+		if err != nil {
+			return "", err
+		}
+		if mode == "100755" {
+			return "", fmt.Errorf("%s is executable, Gitea cannot commit file modes: clear its executable bit to commit it as 100644", target)
+		}
+		change := map[string]string{
+			"operation": "create",
+			"path":      target,
+			"content":   base64.StdEncoding.EncodeToString(content),
+		}
+		// Updating a file requires the SHA of the blob being replaced
+		var existing struct {
+			SHA string `json:"sha"`
+		}
+		query := url.Values{"ref": {ref}}
+		_, err = g.api.request(ctx, http.MethodGet, g.repoPath("/contents/"+escapePath(target)), query, nil, &existing)
+		switch {
+		case err == nil:
+			change["operation"] = "update"
+			change["sha"] = existing.SHA
+		case !IsNotFound(err):
+			return "", err
+		}
+		files = append(files, change)
+	}
+	body["files"] = files
+
+	var resp struct {
+		Commit giteaCommit `json:"commit"`
+	}
+	if _, err := g.api.request(ctx, http.MethodPost, g.repoPath("/contents"), nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Commit.SHA, nil
+}
+
+// CreateMergeRequest implements Forge. Gitea calls them pull requests.
+func (g *Gitea) CreateMergeRequest(ctx context.Context, mr MergeRequest) error {
+	if mr.Title == "" {
+		return errors.New("missing `-pr-title` flag; skipping PR creation")
+	}
+	head := mr.Branch
+	target := *g
+	if mr.Owner != "" && mr.Owner != g.owner {
+		head = g.owner + ":" + mr.Branch
+		target.owner = mr.Owner
+	}
+	if mr.Repo != "" {
+		target.repo = mr.Repo
+	}
+	body := map[string]string{
+		"head":  head,
+		"base":  mr.Base,
+		"title": mr.Title,
+		"body":  mr.Body,
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if _, err := g.api.request(ctx, http.MethodPost, target.repoPath("/pulls"), nil, body, &created); err != nil {
+		return err
+	}
+	fmt.Printf("PR created: %s\n", created.HTMLURL)
+	return nil
+}
+
+// LatestRelease implements Forge.
+func (g *Gitea) LatestRelease(ctx context.Context) (string, error) {
+	var releases []struct {
+		TagName    string `json:"tag_name"`
+		Draft      bool   `json:"draft"`
+		Prerelease bool   `json:"prerelease"`
+	}
+	query := url.Values{"draft": {"false"}, "pre-release": {"false"}, "limit": {"1"}}
+	if _, err := g.api.request(ctx, http.MethodGet, g.repoPath("/releases"), query, nil, &releases); err != nil {
+		return "", err
+	}
+	if len(releases) == 0 {
+		return "", errors.New("repository has no releases")
+	}
+	return releases[0].TagName, nil
+}
+
+// ResolveCommit implements Forge.
+func (g *Gitea) ResolveCommit(ctx context.Context, tag string, ref string, commit string) (string, error) {
+	switch {
+	case commit != "":
+		return g.commitSHA(ctx, commit)
+	case ref != "":
+		return g.commitSHA(ctx, ref)
+	}
+	if tag == "" || tag == "latest" {
+		latest, err := g.LatestRelease(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to find the latest release: %w", err)
+		}
+		tag = latest
+	}
+	var t struct {
+		Commit struct {
+			SHA string `json:"sha"`
+		} `json:"commit"`
+	}
+	if _, err := g.api.request(ctx, http.MethodGet, g.repoPath("/tags/"+url.PathEscape(tag)), nil, nil, &t); err != nil {
+		return "", fmt.Errorf("unable to find tag %s: %w", tag, err)
+	}
+	return t.Commit.SHA, nil
+}
+
+// commitSHA resolves a SHA, branch or tag name to a commit SHA.
+func (g *Gitea) commitSHA(ctx context.Context, rev string) (string, error) {
+	var c giteaCommit
+	query := url.Values{"stat": {"false"}, "files": {"false"}}
+	if _, err := g.api.request(ctx, http.MethodGet, g.repoPath("/git/commits/"+url.PathEscape(rev)), query, nil, &c); err != nil {
+		return "", fmt.Errorf("unable to resolve %s: %w", rev, err)
+	}
+	return c.SHA, nil
+}
+
+// ListFiles implements Forge. Gitea pages through the recursive tree of the
+// commit, which is filtered by dir.
+func (g *Gitea) ListFiles(ctx context.Context, sha string, dir string) ([]string, error) {
+	var files []string
+	seen := 0
+	for page := 1; ; page++ {
+		var tree struct {
+			Tree []struct {
+				Path string `json:"path"`
+				Type string `json:"type"`
+			} `json:"tree"`
+			TotalCount int `json:"total_count"`
+		}
+		query := url.Values{"recursive": {"true"}, "per_page": {"1000"}, "page": {strconv.Itoa(page)}}
+		if _, err := g.api.request(ctx, http.MethodGet, g.repoPath("/git/trees/"+url.PathEscape(sha)), query, nil, &tree); err != nil {
+			return nil, err
+		}
+		for _, e := range tree.Tree {
+			if e.Type == "blob" && inDir(e.Path, dir) {
+				files = append(files, e.Path)
+			}
+		}
+		seen += len(tree.Tree)
+		if len(tree.Tree) == 0 || seen >= tree.TotalCount {
+			return files, nil
+		}
+	}
+}
+
+// Download implements Forge.
+func (g *Gitea) Download(ctx context.Context, sha string, path string, sha256 string, dest string) error {
+	query := url.Values{"ref": {sha}}
+	var file struct {
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+		Size int64  `json:"size"`
+	}
+	if _, err := g.api.request(ctx, http.MethodGet, g.repoPath("/contents/"+escapePath(path)), query, nil, &file); err != nil {
+		return fmt.Errorf("unable to find %s at %s: %w", path, sha, err)
+	}
+	if file.Type != "file" {
+		return fmt.Errorf("%s is not a file", path)
+	}
+	if err := download.CheckSize(file.Size, download.DefaultLimits.MaxSize); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	content, err := g.api.fetch(ctx, g.repoPath("/raw/"+escapePath(path)), query)
+	if err != nil {
+		return err
+	}
+	return saveFile(path, content, file.SHA, sha256, dest)
+}
+
+// MergedCommit implements Forge.
+func (g *Gitea) MergedCommit(ctx context.Context, mr MergeRequest) (string, error) {
+	var pr struct {
+		State          string `json:"state"`
+		Merged         bool   `json:"merged"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+		HTMLURL        string `json:"html_url"`
+	}
+	head := mr.Branch
+	if mr.HeadOwner != "" {
+		head = mr.HeadOwner + ":" + mr.Branch
+	}
+	_, err := g.api.request(ctx, http.MethodGet, g.repoPath("/pulls/"+url.PathEscape(mr.Base)+"/"+url.PathEscape(head)), nil, nil, &pr)
+	switch {
+	case IsNotFound(err):
+		return "", fmt.Errorf("no pull request from %s into %s", mr.Branch, mr.Base)
+	case err != nil:
+		return "", err
+	case pr.Merged:
+		return pr.MergeCommitSHA, nil
+	case pr.State == "closed":
+		return "", fmt.Errorf("pull request %s was closed without merging", pr.HTMLURL)
+	}
+	return "", nil
+}
+
+// CreateRelease implements Forge. Gitea creates the tag along with the
+// release.
+func (g *Gitea) CreateRelease(ctx context.Context, r Release) (string, error) {
+	body := map[string]string{
+		"tag_name":         r.Tag,
+		"target_commitish": r.Commit,
+		"name":             r.Name,
+		"body":             r.Body,
+	}
+	var release struct {
+		ID      int64  `json:"id"`
+		HTMLURL string `json:"html_url"`
+	}
+	if _, err := g.api.request(ctx, http.MethodPost, g.repoPath("/releases"), nil, body, &release); err != nil {
+		return "", err
+	}
+	for _, asset := range r.Assets {
+		query := url.Values{"name": {filepath.Base(asset)}}
+		assetsPath := g.repoPath(fmt.Sprintf("/releases/%d/assets", release.ID))
+		if err := g.api.upload(ctx, assetsPath, query, "attachment", asset, nil); err != nil {
+			return "", fmt.Errorf("unable to attach %s to the release: %w", asset, err)
+		}
+	}
+	return release.HTMLURL, nil
+}
@@ -0,0 +1,229 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// giteaFake serves the Gitea v1 API calls of the adapter for the jdoe/repo
+// repository out of a fakeRepo.
+type giteaFake struct {
+	*fakeRepo
+}
+
+// giteaPageSize is the most entries Gitea returns per page by default.
+const giteaPageSize = 50
+
+func newGiteaForge(t *testing.T) testForge {
+	t.Helper()
+	repo := newFakeRepo()
+	server := httptest.NewServer(giteaFake{repo})
+	t.Cleanup(server.Close)
+	return testForge{
+		name:  "gitea",
+		forge: NewGitea(server.Client(), server.URL, "token", "jdoe", "repo"),
+		repo:  repo,
+	}
+}
+
+func (s giteaFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/repos/jdoe/repo")
+	if rest == r.URL.Path || r.Header.Get("Authorization") != "token token" {
+		http.NotFound(w, r)
+		return
+	}
+	arg := func(route string) string {
+		return strings.TrimPrefix(rest, route)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(rest, "/branches/"):
+		if _, ok := s.branches[arg("/branches/")]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]string{})
+	case rest == "/contents" && r.Method == http.MethodPost:
+		s.changeFiles(w, r)
+	case strings.HasPrefix(rest, "/contents/"):
+		f, ok := s.lookup(r.URL.Query().Get("ref"), arg("/contents/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"type": "file", "sha": s.blobSHA(f), "size": len(f.content)})
+	case strings.HasPrefix(rest, "/raw/"):
+		f, ok := s.lookup(r.URL.Query().Get("ref"), arg("/raw/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(f.content)
+	case strings.HasPrefix(rest, "/git/commits/"):
+		sha, ok := s.resolve(arg("/git/commits/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]string{"sha": sha})
+	case strings.HasPrefix(rest, "/tags/"):
+		sha, ok := s.tags[arg("/tags/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"commit": map[string]string{"sha": sha}})
+	case strings.HasPrefix(rest, "/git/trees/"):
+		sha, ok := s.resolve(arg("/git/trees/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		all := s.tree(sha)
+		entries, _ := page(all, r.URL.Query().Get("per_page"), r.URL.Query().Get("page"), giteaPageSize)
+		tree := []map[string]string{}
+		for _, e := range entries {
+			tree = append(tree, map[string]string{"path": e.path, "type": e.typ})
+		}
+		writeJSON(w, map[string]interface{}{"tree": tree, "total_count": len(all)})
+	case rest == "/pulls" && r.Method == http.MethodPost:
+		var req struct {
+			Head string `json:"head"`
+			Base string `json:"base"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mergeRequests = append(s.mergeRequests, &fakeMergeRequest{branch: req.Head, base: req.Base, state: "open"})
+		writeJSON(w, map[string]string{"html_url": "https://gitea.example.com/jdoe/repo/pulls/1"})
+	case strings.HasPrefix(rest, "/pulls/"):
+		// /pulls/{base}/{head}
+		base, head, _ := strings.Cut(arg("/pulls/"), "/")
+		mr := s.mergeRequest(head, base)
+		if mr == nil {
+			http.NotFound(w, r)
+			return
+		}
+		state := "open"
+		if mr.state != "open" {
+			state = "closed"
+		}
+		writeJSON(w, map[string]interface{}{
+			"state":            state,
+			"merged":           mr.state == "merged",
+			"merge_commit_sha": mr.mergeSHA,
+			"html_url":         "https://gitea.example.com/jdoe/repo/pulls/1",
+		})
+	case rest == "/releases" && r.Method == http.MethodPost:
+		var req struct {
+			TagName         string `json:"tag_name"`
+			TargetCommitish string `json:"target_commitish"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !s.createRelease(req.TagName, req.TargetCommitish) {
+			http.Error(w, "release already exists", http.StatusConflict)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"id": len(s.releases), "html_url": "https://gitea.example.com/jdoe/repo/releases/tag/" + req.TagName})
+	case strings.HasPrefix(rest, "/releases/") && strings.HasSuffix(rest, "/assets"):
+		// Release ids count the releases from 1, oldest first
+		id, err := strconv.Atoi(strings.TrimSuffix(arg("/releases/"), "/assets"))
+		if err != nil || id < 1 || id > len(s.releases) {
+			http.NotFound(w, r)
+			return
+		}
+		content, _, err := readForm(r, "attachment")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tag := s.releases[len(s.releases)-id]
+		s.assets[tag][r.URL.Query().Get("name")] = content
+		writeJSON(w, map[string]string{})
+	case rest == "/releases":
+		releases := []map[string]interface{}{}
+		for _, tag := range s.releases {
+			releases = append(releases, map[string]interface{}{"tag_name": tag})
+		}
+		writeJSON(w, releases)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// changeFiles applies a change files API request. Updates must name the
+// blob they replace, as Gitea requires.
+func (s giteaFake) changeFiles(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Branch    string `json:"branch"`
+		NewBranch string `json:"new_branch"`
+		Files     []struct {
+			Operation string `json:"operation"`
+			Path      string `json:"path"`
+			Content   string `json:"content"`
+			SHA       string `json:"sha"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parent, ok := s.branches[req.Branch]
+	if !ok {
+		http.Error(w, "branch does not exist", http.StatusNotFound)
+		return
+	}
+	branch := req.Branch
+	if req.NewBranch != "" {
+		if _, exists := s.branches[req.NewBranch]; exists {
+			http.Error(w, "branch already exists", http.StatusUnprocessableEntity)
+			return
+		}
+		branch = req.NewBranch
+	}
+	changes := map[string]fakeFile{}
+	for _, f := range req.Files {
+		old, present := s.commits[parent].files[f.Path]
+		switch {
+		case f.Operation == "create" && present,
+			f.Operation == "update" && (!present || f.SHA != s.blobSHA(old)):
+			http.Error(w, "invalid operation on "+f.Path, http.StatusUnprocessableEntity)
+			return
+		}
+		content, err := base64.StdEncoding.DecodeString(f.Content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		changes[f.Path] = fakeFile{content: content}
+	}
+	sha := s.commit(parent, changes)
+	s.branches[branch] = sha
+	writeJSON(w, map[string]interface{}{"commit": map[string]string{"sha": sha}})
+}
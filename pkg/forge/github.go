@@ -0,0 +1,146 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/lukehinds/sap/pkg/githubapi"
+)
+
+// GitHub stores materials in a repository on GitHub.
+type GitHub struct {
+	client *github.Client
+	owner  string
+	repo   string
+}
+
+// NewGitHub returns a forge backed by the owner/repo GitHub repository.
+func NewGitHub(client *github.Client, owner string, repo string) *GitHub {
+	return &GitHub{client: client, owner: owner, repo: repo}
+}
+
+// CommitFiles implements Forge.
+func (g *GitHub) CommitFiles(ctx context.Context, c Commit) (string, error) {
+	ref, err := githubapi.GetRef(ctx, g.client, g.owner, g.repo, "", c.Branch, c.BaseBranch)
+	if err != nil {
+		return "", err
+	}
+	if ref == nil {
+		return "", errors.New("no error where returned but the reference is nil")
+	}
+	tree, err := githubapi.GetTree(ctx, g.client, ref, strings.Join(c.Files, ","), g.owner, g.repo)
+	if err != nil {
+		return "", err
+	}
+	if err := githubapi.PushCommit(ctx, g.client, ref, tree, g.owner, g.repo, c.AuthorName, c.AuthorEmail, c.Message); err != nil {
+		return "", err
+	}
+	return ref.GetObject().GetSHA(), nil
+}
+
+// CreateMergeRequest implements Forge.
+func (g *GitHub) CreateMergeRequest(ctx context.Context, mr MergeRequest) error {
+	return githubapi.CreatePR(ctx, g.client, mr.Owner, mr.Repo, g.owner, mr.Branch, g.repo, mr.Title, mr.Base, mr.Body)
+}
+
+// LatestRelease implements Forge.
+func (g *GitHub) LatestRelease(ctx context.Context) (string, error) {
+	release, _, err := g.client.Repositories.GetLatestRelease(ctx, g.owner, g.repo)
+	if err != nil {
+		return "", err
+	}
+	return release.GetTagName(), nil
+}
+
+// ResolveCommit implements Forge.
+func (g *GitHub) ResolveCommit(ctx context.Context, tag string, ref string, commit string) (string, error) {
+	return githubapi.ResolveCommit(ctx, g.client, g.owner, g.repo, tag, ref, commit)
+}
+
+// ListFiles implements Forge.
+func (g *GitHub) ListFiles(ctx context.Context, sha string, dir string) ([]string, error) {
+	return githubapi.ListFiles(ctx, g.client, g.owner, g.repo, sha, dir)
+}
+
+// Download implements Forge.
+func (g *GitHub) Download(ctx context.Context, sha string, path string, sha256 string, dest string) error {
+	content, blob, err := githubapi.DownloadFile(ctx, g.client, g.owner, g.repo, path, sha)
+	if err != nil {
+		return err
+	}
+	return saveFile(path, content, blob, sha256, dest)
+}
+
+// MergedCommit implements Forge. The most recent pull request from the branch
+// into the base counts.
+func (g *GitHub) MergedCommit(ctx context.Context, mr MergeRequest) (string, error) {
+	headOwner := mr.HeadOwner
+	if headOwner == "" {
+		headOwner = g.owner
+	}
+	prs, _, err := g.client.PullRequests.List(ctx, g.owner, g.repo, &github.PullRequestListOptions{
+		State: "all",
+		Head:  headOwner + ":" + mr.Branch,
+		Base:  mr.Base,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(prs) == 0 {
+		return "", fmt.Errorf("no pull request from %s into %s", mr.Branch, mr.Base)
+	}
+	pr := prs[0]
+	switch {
+	case pr.MergedAt != nil:
+		return pr.GetMergeCommitSHA(), nil
+	case pr.GetState() == "closed":
+		return "", fmt.Errorf("pull request %s was closed without merging", pr.GetHTMLURL())
+	}
+	return "", nil
+}
+
+// CreateRelease implements Forge. GitHub creates the tag along with the
+// release.
+func (g *GitHub) CreateRelease(ctx context.Context, r Release) (string, error) {
+	release, _, err := g.client.Repositories.CreateRelease(ctx, g.owner, g.repo, &github.RepositoryRelease{
+		TagName:         github.String(r.Tag),
+		TargetCommitish: github.String(r.Commit),
+		Name:            github.String(r.Name),
+		Body:            github.String(r.Body),
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, asset := range r.Assets {
+		f, err := os.Open(asset)
+		if err != nil {
+			return "", err
+		}
+		_, _, err = g.client.Repositories.UploadReleaseAsset(ctx, g.owner, g.repo, release.GetID(), &github.UploadOptions{Name: filepath.Base(asset)}, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("unable to attach %s to the release: %w", asset, err)
+		}
+	}
+	return release.GetHTMLURL(), nil
+}
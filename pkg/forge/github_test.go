@@ -0,0 +1,144 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/lukehinds/sap/pkg/download"
+)
+
+// githubStandIn serves the git data API calls of a commit to the jdoe/repo
+// repository and keeps the blobs and tree entries it was sent.
+type githubStandIn struct {
+	refs    map[string]string
+	blobs   map[string][]byte
+	entries map[string]github.TreeEntry
+	commits int
+}
+
+// newGitHubForge returns the GitHub adapter talking to a stand-in. The
+// stand-in only keeps the tree of the last commit, so it backs the commit
+// tests alone.
+func newGitHubForge(t *testing.T) testForge {
+	t.Helper()
+	s, g := newGitHubStandIn(t)
+	return testForge{name: "github", forge: g, repo: s}
+}
+
+func newGitHubStandIn(t *testing.T) (*githubStandIn, *GitHub) {
+	s := &githubStandIn{
+		refs:    map[string]string{"heads/main": fmt.Sprintf("%040x", 1)},
+		blobs:   map[string][]byte{},
+		entries: map[string]github.TreeEntry{},
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	client := github.NewClient(server.Client())
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return s, NewGitHub(client, "jdoe", "repo")
+}
+
+func (s *githubStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/repos/jdoe/repo")
+	switch {
+	case strings.HasPrefix(rest, "/git/ref/") && r.Method == http.MethodGet:
+		ref := strings.TrimPrefix(rest, "/git/ref/")
+		sha, ok := s.refs[ref]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"ref": "refs/" + ref, "object": map[string]string{"sha": sha}})
+	case rest == "/git/refs" && r.Method == http.MethodPost:
+		var ref struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		}
+		json.NewDecoder(r.Body).Decode(&ref)
+		s.refs[strings.TrimPrefix(ref.Ref, "refs/")] = ref.SHA
+		writeJSON(w, map[string]interface{}{"ref": ref.Ref, "object": map[string]string{"sha": ref.SHA}})
+	case strings.HasPrefix(rest, "/git/refs/") && r.Method == http.MethodPatch:
+		var ref struct {
+			SHA string `json:"sha"`
+		}
+		json.NewDecoder(r.Body).Decode(&ref)
+		name := strings.TrimPrefix(rest, "/git/refs/")
+		s.refs[name] = ref.SHA
+		writeJSON(w, map[string]interface{}{"ref": "refs/" + name, "object": map[string]string{"sha": ref.SHA}})
+	case rest == "/git/blobs" && r.Method == http.MethodPost:
+		var blob struct {
+			Content  string `json:"content"`
+			Encoding string `json:"encoding"`
+		}
+		json.NewDecoder(r.Body).Decode(&blob)
+		if blob.Encoding != "base64" {
+			http.Error(w, "unexpected encoding "+blob.Encoding, http.StatusBadRequest)
+			return
+		}
+		content, err := base64.StdEncoding.DecodeString(blob.Content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sha := download.BlobSHA(content)
+		s.blobs[sha] = content
+		writeJSON(w, map[string]string{"sha": sha})
+	case rest == "/git/trees" && r.Method == http.MethodPost:
+		var tree struct {
+			Tree []github.TreeEntry `json:"tree"`
+		}
+		json.NewDecoder(r.Body).Decode(&tree)
+		for _, e := range tree.Tree {
+			s.entries[e.GetPath()] = e
+		}
+		writeJSON(w, map[string]string{"sha": fmt.Sprintf("%040x", 50)})
+	case strings.HasPrefix(rest, "/commits/"):
+		sha := strings.TrimPrefix(rest, "/commits/")
+		writeJSON(w, map[string]interface{}{"sha": sha, "commit": map[string]string{"message": "parent"}})
+	case rest == "/git/commits" && r.Method == http.MethodPost:
+		s.commits++
+		writeJSON(w, map[string]string{"sha": fmt.Sprintf("%040x", 100+s.commits)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *githubStandIn) branch(t *testing.T, name string) string {
+	return s.refs["heads/"+name]
+}
+
+func (s *githubStandIn) file(t *testing.T, sha string, path string) ([]byte, string, bool) {
+	e, ok := s.entries[path]
+	return s.blobs[e.GetSHA()], e.GetMode(), ok
+}
+
+func (s *githubStandIn) release(t *testing.T, tag string, sha string) {
+	t.Fatal("the GitHub stand-in has no releases")
+}
+
+func (s *githubStandIn) merge(t *testing.T, branch string, base string) string {
+	t.Fatal("the GitHub stand-in has no pull requests")
+	return ""
+}
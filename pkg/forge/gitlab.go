@@ -0,0 +1,354 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/utils"
+)
+
+// DefaultGitLabURL is the GitLab instance used when no base URL is given.
+const DefaultGitLabURL = "https://gitlab.com"
+
+// GitLab stores materials in a project on a GitLab instance, using the v4
+// REST API.
+type GitLab struct {
+	api     *apiClient
+	project string
+}
+
+// NewGitLab returns a forge backed by the owner/repo project (owner may
+// include subgroups) on the GitLab instance at baseURL.
+func NewGitLab(httpClient *http.Client, baseURL string, token string, owner string, repo string) *GitLab {
+	if baseURL == "" {
+		baseURL = DefaultGitLabURL
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &GitLab{
+		api: &apiClient{
+			base:       baseURL + "/api/v4",
+			authHeader: "PRIVATE-TOKEN",
+			authValue:  token,
+			http:       httpClient,
+		},
+		project: owner + "/" + repo,
+	}
+}
+
+// projectPath returns the API path of the project followed by elem.
+func (g *GitLab) projectPath(project string, elem string) string {
+	return "/projects/" + url.PathEscape(project) + elem
+}
+
+type gitlabCommit struct {
+	ID string `json:"id"`
+}
+
+// CommitFiles implements Forge. All files are committed in a single commit
+// through the commits API.
+func (g *GitLab) CommitFiles(ctx context.Context, c Commit) (string, error) {
+	body := map[string]interface{}{
+		"branch":         c.Branch,
+		"commit_message": c.Message,
+		"author_name":    c.AuthorName,
+		"author_email":   c.AuthorEmail,
+	}
+
+	ref := c.Branch
+	_, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/repository/branches/"+url.PathEscape(c.Branch)), nil, nil, nil)
+	switch {
+	case IsNotFound(err):
+		if c.Branch == c.BaseBranch {
+			return "", errors.New("the commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+		}
+		if c.BaseBranch == "" {
+			return "", errors.New("the `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+		}
+		body["start_branch"] = c.BaseBranch
+		ref = c.BaseBranch
+	case err != nil:
+		return "", err
+	}
+
+	var actions []map[string]interface{}
+	for _, file := range c.Files {
+		target, content, err := readLocal(file)
+		if err != nil {
+			return "", err
+		}
+		local, _ := splitFile(file)
+		mode, err := utils.GitFileMode(local)
+		if err != nil {
+			return "", err
+		}
+		// GitLab refuses to create a file that exists or update one that
+		// does not, so check which one applies
+		action := "update"
+		query := url.Values{"ref": {ref}}
+		_, err = g.api.request(ctx, http.MethodHead, g.projectPath(g.project, "/repository/files/"+url.PathEscape(target)), query, nil, nil)
+		if IsNotFound(err) {
+			action = "create"
+		} else if err != nil {
+			return "", err
+		}
+		actions = append(actions, map[string]interface{}{
+			"action":           action,
+			"file_path":        target,
+			"content":          base64.StdEncoding.EncodeToString(content),
+			"encoding":         "base64",
+			"execute_filemode": mode == "100755",
+		})
+	}
+	body["actions"] = actions
+
+	var commit gitlabCommit
+	if _, err := g.api.request(ctx, http.MethodPost, g.projectPath(g.project, "/repository/commits"), nil, body, &commit); err != nil {
+		return "", err
+	}
+	return commit.ID, nil
+}
+
+// CreateMergeRequest implements Forge.
+func (g *GitLab) CreateMergeRequest(ctx context.Context, mr MergeRequest) error {
+	if mr.Title == "" {
+		return errors.New("missing `-pr-title` flag; skipping PR creation")
+	}
+	body := map[string]interface{}{
+		"source_branch": mr.Branch,
+		"target_branch": mr.Base,
+		"title":         mr.Title,
+		"description":   mr.Body,
+	}
+	if mr.Owner != "" || mr.Repo != "" {
+		target := g.targetProject(mr)
+		if target != g.project {
+			var project struct {
+				ID int `json:"id"`
+			}
+			if _, err := g.api.request(ctx, http.MethodGet, g.projectPath(target, ""), nil, nil, &project); err != nil {
+				return err
+			}
+			body["target_project_id"] = project.ID
+		}
+	}
+
+	var created struct {
+		WebURL string `json:"web_url"`
+	}
+	if _, err := g.api.request(ctx, http.MethodPost, g.projectPath(g.project, "/merge_requests"), nil, body, &created); err != nil {
+		return err
+	}
+	fmt.Printf("Merge request created: %s\n", created.WebURL)
+	return nil
+}
+
+// targetProject returns the project path a merge request is opened against.
+func (g *GitLab) targetProject(mr MergeRequest) string {
+	owner, repo := splitProject(g.project)
+	if mr.Owner != "" {
+		owner = mr.Owner
+	}
+	if mr.Repo != "" {
+		repo = mr.Repo
+	}
+	return owner + "/" + repo
+}
+
+// LatestRelease implements Forge.
+func (g *GitLab) LatestRelease(ctx context.Context) (string, error) {
+	var releases []struct {
+		TagName string `json:"tag_name"`
+	}
+	query := url.Values{"order_by": {"released_at"}, "sort": {"desc"}, "per_page": {"1"}}
+	if _, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/releases"), query, nil, &releases); err != nil {
+		return "", err
+	}
+	if len(releases) == 0 {
+		return "", errors.New("project has no releases")
+	}
+	return releases[0].TagName, nil
+}
+
+// ResolveCommit implements Forge.
+func (g *GitLab) ResolveCommit(ctx context.Context, tag string, ref string, commit string) (string, error) {
+	switch {
+	case commit != "":
+		return g.commitSHA(ctx, commit)
+	case ref != "":
+		return g.commitSHA(ctx, ref)
+	}
+	if tag == "" || tag == "latest" {
+		latest, err := g.LatestRelease(ctx)
+		if err != nil {
+			return "", fmt.Errorf("unable to find the latest release: %w", err)
+		}
+		tag = latest
+	}
+	var t struct {
+		Commit gitlabCommit `json:"commit"`
+	}
+	if _, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/repository/tags/"+url.PathEscape(tag)), nil, nil, &t); err != nil {
+		return "", fmt.Errorf("unable to find tag %s: %w", tag, err)
+	}
+	return t.Commit.ID, nil
+}
+
+// commitSHA resolves a SHA, branch or tag name to a commit SHA.
+func (g *GitLab) commitSHA(ctx context.Context, rev string) (string, error) {
+	var c gitlabCommit
+	if _, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/repository/commits/"+url.PathEscape(rev)), nil, nil, &c); err != nil {
+		return "", fmt.Errorf("unable to resolve %s: %w", rev, err)
+	}
+	return c.ID, nil
+}
+
+// ListFiles implements Forge.
+func (g *GitLab) ListFiles(ctx context.Context, sha string, dir string) ([]string, error) {
+	var files []string
+	for page := 1; ; page++ {
+		var entries []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+		}
+		query := url.Values{"ref": {sha}, "recursive": {"true"}, "per_page": {"100"}, "page": {strconv.Itoa(page)}}
+		if dir != "" {
+			query.Set("path", dir)
+		}
+		resp, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/repository/tree"), query, nil, &entries)
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type == "blob" {
+				files = append(files, e.Path)
+			}
+		}
+		if resp.Header.Get("X-Next-Page") == "" {
+			return files, nil
+		}
+	}
+}
+
+// Download implements Forge.
+func (g *GitLab) Download(ctx context.Context, sha string, path string, sha256 string, dest string) error {
+	query := url.Values{"ref": {sha}}
+	filePath := g.projectPath(g.project, "/repository/files/"+url.PathEscape(path))
+
+	// The file metadata comes back as headers of a HEAD request
+	resp, err := g.api.request(ctx, http.MethodHead, filePath, query, nil, nil)
+	if err != nil {
+		return fmt.Errorf("unable to find %s at %s: %w", path, sha, err)
+	}
+	if size, err := strconv.ParseInt(resp.Header.Get("X-Gitlab-Size"), 10, 64); err == nil {
+		if err := download.CheckSize(size, download.DefaultLimits.MaxSize); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	content, err := g.api.fetch(ctx, filePath+"/raw", query)
+	if err != nil {
+		return err
+	}
+	return saveFile(path, content, resp.Header.Get("X-Gitlab-Blob-Id"), sha256, dest)
+}
+
+// MergedCommit implements Forge. The most recent merge request from the
+// branch into the base counts.
+func (g *GitLab) MergedCommit(ctx context.Context, mr MergeRequest) (string, error) {
+	var mrs []struct {
+		State           string `json:"state"`
+		SHA             string `json:"sha"`
+		MergeCommitSHA  string `json:"merge_commit_sha"`
+		SquashCommitSHA string `json:"squash_commit_sha"`
+		WebURL          string `json:"web_url"`
+	}
+	query := url.Values{
+		"source_branch": {mr.Branch},
+		"target_branch": {mr.Base},
+		"state":         {"all"},
+		"order_by":      {"created_at"},
+		"sort":          {"desc"},
+		"per_page":      {"1"},
+	}
+	if _, err := g.api.request(ctx, http.MethodGet, g.projectPath(g.project, "/merge_requests"), query, nil, &mrs); err != nil {
+		return "", err
+	}
+	if len(mrs) == 0 {
+		return "", fmt.Errorf("no merge request from %s into %s", mr.Branch, mr.Base)
+	}
+	switch m := mrs[0]; m.State {
+	case "merged":
+		// Fast forward merges leave no merge commit, the branch head is
+		// what was merged
+		for _, sha := range []string{m.MergeCommitSHA, m.SquashCommitSHA, m.SHA} {
+			if sha != "" {
+				return sha, nil
+			}
+		}
+		return "", fmt.Errorf("merge request %s has no merge commit", m.WebURL)
+	case "closed":
+		return "", fmt.Errorf("merge request %s was closed without merging", m.WebURL)
+	}
+	return "", nil
+}
+
+// CreateRelease implements Forge. GitLab creates the tag along with the
+// release, assets are uploaded to the project and linked from it.
+func (g *GitLab) CreateRelease(ctx context.Context, r Release) (string, error) {
+	var links []map[string]string
+	for _, asset := range r.Assets {
+		var uploaded struct {
+			FullPath string `json:"full_path"`
+		}
+		if err := g.api.upload(ctx, g.projectPath(g.project, "/uploads"), nil, "file", asset, &uploaded); err != nil {
+			return "", fmt.Errorf("unable to upload %s: %w", asset, err)
+		}
+		links = append(links, map[string]string{
+			"name": filepath.Base(asset),
+			"url":  strings.TrimSuffix(g.api.base, "/api/v4") + uploaded.FullPath,
+		})
+	}
+	body := map[string]interface{}{
+		"tag_name":    r.Tag,
+		"ref":         r.Commit,
+		"name":        r.Name,
+		"description": r.Body,
+		"assets":      map[string]interface{}{"links": links},
+	}
+	var release struct {
+		Links struct {
+			Self string `json:"self"`
+		} `json:"_links"`
+	}
+	if _, err := g.api.request(ctx, http.MethodPost, g.projectPath(g.project, "/releases"), nil, body, &release); err != nil {
+		return "", err
+	}
+	return release.Links.Self, nil
+}
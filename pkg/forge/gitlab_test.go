@@ -0,0 +1,255 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// gitlabFake serves the GitLab v4 API calls of the adapter for the jdoe/repo
+// project out of a fakeRepo.
+type gitlabFake struct {
+	*fakeRepo
+	// uploads holds the uploaded files by path
+	uploads map[string][]byte
+}
+
+// gitlabPageSize is the most entries GitLab returns per page.
+const gitlabPageSize = 100
+
+func newGitLabForge(t *testing.T) testForge {
+	t.Helper()
+	repo := newFakeRepo()
+	server := httptest.NewServer(gitlabFake{repo, map[string][]byte{}})
+	t.Cleanup(server.Close)
+	return testForge{
+		name:  "gitlab",
+		forge: NewGitLab(server.Client(), server.URL, "token", "jdoe", "repo"),
+		repo:  repo,
+	}
+}
+
+func (s gitlabFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/api/v4/projects/" + url.PathEscape("jdoe/repo")
+	rest := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	if rest == r.URL.EscapedPath() || r.Header.Get("PRIVATE-TOKEN") != "token" {
+		http.NotFound(w, r)
+		return
+	}
+	// File paths and refs come as a single escaped path element
+	arg := func(route string) string {
+		a, _ := url.PathUnescape(strings.TrimPrefix(rest, route))
+		return a
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(rest, "/repository/branches/"):
+		if _, ok := s.branches[arg("/repository/branches/")]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]string{})
+	case strings.HasPrefix(rest, "/repository/files/"):
+		name := arg("/repository/files/")
+		raw := strings.HasSuffix(name, "/raw")
+		f, ok := s.lookup(r.URL.Query().Get("ref"), strings.TrimSuffix(name, "/raw"))
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+		case raw:
+			w.Write(f.content)
+		default:
+			w.Header().Set("X-Gitlab-Blob-Id", s.blobSHA(f))
+			w.Header().Set("X-Gitlab-Size", strconv.Itoa(len(f.content)))
+		}
+	case rest == "/repository/commits" && r.Method == http.MethodPost:
+		s.commitActions(w, r)
+	case strings.HasPrefix(rest, "/repository/commits/"):
+		sha, ok := s.resolve(arg("/repository/commits/"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]string{"id": sha})
+	case strings.HasPrefix(rest, "/repository/tags/"):
+		sha, ok := s.tags[arg("/repository/tags/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"commit": map[string]string{"id": sha}})
+	case rest == "/repository/tree":
+		s.listTree(w, r)
+	case rest == "/merge_requests" && r.Method == http.MethodPost:
+		var req struct {
+			SourceBranch string `json:"source_branch"`
+			TargetBranch string `json:"target_branch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mergeRequests = append(s.mergeRequests, &fakeMergeRequest{branch: req.SourceBranch, base: req.TargetBranch, state: "open"})
+		writeJSON(w, map[string]string{"web_url": "https://gitlab.example.com/jdoe/repo/-/merge_requests/1"})
+	case rest == "/merge_requests":
+		query := r.URL.Query()
+		mrs := []map[string]string{}
+		if mr := s.mergeRequest(query.Get("source_branch"), query.Get("target_branch")); mr != nil {
+			state := mr.state
+			if state == "open" {
+				state = "opened"
+			}
+			mrs = append(mrs, map[string]string{"state": state, "sha": mr.head, "merge_commit_sha": mr.mergeSHA, "web_url": "https://gitlab.example.com/jdoe/repo/-/merge_requests/1"})
+		}
+		writeJSON(w, mrs)
+	case rest == "/uploads" && r.Method == http.MethodPost:
+		content, name, err := readForm(r, "file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fullPath := fmt.Sprintf("/-/project/1/uploads/%d/%s", len(s.uploads), name)
+		s.uploads[fullPath] = content
+		writeJSON(w, map[string]string{"full_path": fullPath})
+	case rest == "/releases" && r.Method == http.MethodPost:
+		s.createRelease(w, r)
+	case rest == "/releases":
+		releases := []map[string]string{}
+		for _, tag := range s.releases {
+			releases = append(releases, map[string]string{"tag_name": tag})
+		}
+		writeJSON(w, releases)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// commitActions applies the actions of a commits API request, refusing to
+// create files that exist or update files that do not, as GitLab does.
+func (s gitlabFake) commitActions(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Branch      string `json:"branch"`
+		StartBranch string `json:"start_branch"`
+		Actions     []struct {
+			Action          string `json:"action"`
+			FilePath        string `json:"file_path"`
+			Content         string `json:"content"`
+			Encoding        string `json:"encoding"`
+			ExecuteFilemode bool   `json:"execute_filemode"`
+		} `json:"actions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parent, ok := s.branches[req.Branch]
+	if !ok {
+		if parent, ok = s.branches[req.StartBranch]; !ok {
+			http.Error(w, "branch does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+	changes := map[string]fakeFile{}
+	for _, a := range req.Actions {
+		_, present := s.commits[parent].files[a.FilePath]
+		if (a.Action == "create" && present) || (a.Action == "update" && !present) || a.Encoding != "base64" {
+			http.Error(w, "invalid action on "+a.FilePath, http.StatusBadRequest)
+			return
+		}
+		content, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		changes[a.FilePath] = fakeFile{content: content, executable: a.ExecuteFilemode}
+	}
+	sha := s.commit(parent, changes)
+	s.branches[req.Branch] = sha
+	writeJSON(w, map[string]string{"id": sha})
+}
+
+// listTree serves a page of the recursive tree of a commit, limited to path.
+func (s gitlabFake) listTree(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sha, ok := s.resolve(query.Get("ref"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var entries []fakeEntry
+	for _, e := range s.tree(sha) {
+		if inDir(e.path, query.Get("path")) {
+			entries = append(entries, e)
+		}
+	}
+	// GitLab answers 404 for a path the commit does not have
+	if len(entries) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	entries, more := page(entries, query.Get("per_page"), query.Get("page"), gitlabPageSize)
+	if more {
+		p, _ := strconv.Atoi(query.Get("page"))
+		w.Header().Set("X-Next-Page", strconv.Itoa(p+1))
+	}
+	tree := []map[string]string{}
+	for _, e := range entries {
+		tree = append(tree, map[string]string{"path": e.path, "type": e.typ})
+	}
+	writeJSON(w, tree)
+}
+
+// createRelease tags the ref and attaches the uploads the asset links point
+// to.
+func (s gitlabFake) createRelease(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TagName string `json:"tag_name"`
+		Ref     string `json:"ref"`
+		Assets  struct {
+			Links []struct {
+				Name string `json:"name"`
+				URL  string `json:"url"`
+			} `json:"links"`
+		} `json:"assets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.fakeRepo.createRelease(req.TagName, req.Ref) {
+		http.Error(w, "release already exists", http.StatusConflict)
+		return
+	}
+	for _, link := range req.Assets.Links {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.assets[req.TagName][link.Name] = s.uploads[u.Path]
+	}
+	writeJSON(w, map[string]interface{}{"_links": map[string]string{"self": "https://gitlab.example.com/jdoe/repo/-/releases/" + req.TagName}})
+}
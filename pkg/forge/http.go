@@ -0,0 +1,190 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/download"
+)
+
+// APIError is a non 2xx response from a forge API.
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 response from a forge API.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// apiClient is a small JSON client shared by the GitLab and Gitea adapters.
+type apiClient struct {
+	base       string
+	authHeader string
+	authValue  string
+	http       *http.Client
+}
+
+// request sends in (if not nil) as JSON and decodes the response into out
+// (if not nil).
+func (c *apiClient) request(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	u := strings.TrimSuffix(c.base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.authValue != "" {
+		req.Header.Set(c.authHeader, c.authValue)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, &APIError{Method: method, URL: u, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("unable to decode response from %s: %w", u, err)
+		}
+	}
+	return resp, nil
+}
+
+// upload posts the content of the local file as the multipart form field
+// and decodes the response into out (if not nil).
+func (c *apiClient) upload(ctx context.Context, path string, query url.Values, field string, file string, out interface{}) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile(field, filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(content); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	u := strings.TrimSuffix(c.base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	if c.authValue != "" {
+		req.Header.Set(c.authHeader, c.authValue)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &APIError{Method: http.MethodPost, URL: u, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("unable to decode response from %s: %w", u, err)
+		}
+	}
+	return nil
+}
+
+// fetch returns the body of a GET request, within the download limits.
+func (c *apiClient) fetch(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	u := strings.TrimSuffix(c.base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	header := http.Header{}
+	if c.authValue != "" {
+		header.Set(c.authHeader, c.authValue)
+	}
+	body, err := download.Fetch(ctx, c.http, u, header, download.Digests{})
+	var status *download.StatusError
+	if errors.As(err, &status) {
+		return nil, &APIError{Method: http.MethodGet, URL: u, StatusCode: status.StatusCode, Message: status.Status}
+	}
+	return body, err
+}
+
+// saveFile checks the content of path against the blob id the forge
+// reported and the expected sha256, then writes it to dest.
+func saveFile(path string, content []byte, blobSHA string, sha256 string, dest string) error {
+	if blobSHA == "" {
+		return fmt.Errorf("%s: no blob id to check the download against", path)
+	}
+	if err := download.Check(content, download.Digests{BlobSHA: blobSHA, SHA256: sha256}); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return download.WriteFile(dest, content)
+}
+
+// readLocal reads a "local[:target]" commit file argument.
+func readLocal(file string) (target string, content []byte, err error) {
+	local, target := splitFile(file)
+	content, err = os.ReadFile(local)
+	return target, content, err
+}
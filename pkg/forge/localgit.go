@@ -0,0 +1,266 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/utils"
+)
+
+// LocalGit stores materials in a git repository on the local filesystem,
+// either bare or with a working tree. Commits are written with git plumbing
+// so the working tree and index of the repository are never touched.
+type LocalGit struct {
+	dir string
+}
+
+// NewLocalGit returns a forge backed by the git repository at dir.
+func NewLocalGit(dir string) (*LocalGit, error) {
+	l := &LocalGit{dir: dir}
+	if _, err := l.git(context.Background(), nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", dir, err)
+	}
+	return l, nil
+}
+
+// CommitFiles implements Forge.
+func (l *LocalGit) CommitFiles(ctx context.Context, c Commit) (string, error) {
+	branchRef := "refs/heads/" + c.Branch
+	parent, err := l.revParse(ctx, branchRef)
+	exists := err == nil
+	if !exists {
+		if c.Branch == c.BaseBranch {
+			return "", errors.New("the commit branch does not exist but `-base-branch` is the same as `-commit-branch`")
+		}
+		if c.BaseBranch == "" {
+			return "", errors.New("the `-base-branch` should not be set to an empty string when the branch specified by `-commit-branch` does not exists")
+		}
+		if parent, err = l.revParse(ctx, "refs/heads/"+c.BaseBranch); err != nil {
+			return "", fmt.Errorf("unable to find base branch %s: %w", c.BaseBranch, err)
+		}
+	}
+
+	// Build the tree in a private index seeded from the parent commit
+	index, err := os.CreateTemp("", "sap-index-")
+	if err != nil {
+		return "", err
+	}
+	index.Close()
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+	env := []string{"GIT_INDEX_FILE=" + index.Name()}
+
+	if _, err := l.git(ctx, env, "read-tree", parent); err != nil {
+		return "", err
+	}
+	for _, file := range c.Files {
+		local, target := splitFile(file)
+		abs, err := filepath.Abs(local)
+		if err != nil {
+			return "", err
+		}
+		mode, err := utils.GitFileMode(abs)
+		if err != nil {
+			return "", err
+		}
+		blob, err := l.git(ctx, nil, "hash-object", "-w", "--no-filters", abs)
+		if err != nil {
+			return "", err
+		}
+		if _, err := l.git(ctx, env, "update-index", "--add", "--cacheinfo", mode+","+blob+","+target); err != nil {
+			return "", err
+		}
+	}
+	tree, err := l.git(ctx, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	author := []string{
+		"GIT_AUTHOR_NAME=" + c.AuthorName, "GIT_AUTHOR_EMAIL=" + c.AuthorEmail,
+		"GIT_COMMITTER_NAME=" + c.AuthorName, "GIT_COMMITTER_EMAIL=" + c.AuthorEmail,
+	}
+	sha, err := l.git(ctx, author, "commit-tree", tree, "-p", parent, "-m", c.Message)
+	if err != nil {
+		return "", err
+	}
+
+	// Only move the branch if nobody else did in the meantime
+	old := ""
+	if exists {
+		old = parent
+	}
+	if _, err := l.git(ctx, nil, "update-ref", branchRef, sha, old); err != nil {
+		return "", err
+	}
+	return sha, nil
+}
+
+// CreateMergeRequest implements Forge. A local repository has no pull requests, so
+// the branch is left for the maintainer to merge.
+func (l *LocalGit) CreateMergeRequest(ctx context.Context, mr MergeRequest) error {
+	fmt.Printf("Local repository: merge branch %s into %s to publish the materials\n", mr.Branch, mr.Base)
+	return nil
+}
+
+// LatestRelease implements Forge. The latest release of a local repository
+// is its most recently created tag.
+func (l *LocalGit) LatestRelease(ctx context.Context) (string, error) {
+	latest, err := l.git(ctx, nil, "for-each-ref", "--sort=-creatordate", "--count=1", "--format=%(refname:short)", "refs/tags")
+	if err != nil {
+		return "", err
+	}
+	if latest == "" {
+		return "", errors.New("repository has no tags")
+	}
+	return latest, nil
+}
+
+// ResolveCommit implements Forge.
+func (l *LocalGit) ResolveCommit(ctx context.Context, tag string, ref string, commit string) (string, error) {
+	switch {
+	case commit != "":
+		return l.revParse(ctx, commit)
+	case ref != "":
+		return l.revParse(ctx, ref)
+	}
+	if tag == "" || tag == "latest" {
+		latest, err := l.LatestRelease(ctx)
+		if err != nil {
+			return "", err
+		}
+		tag = latest
+	}
+	return l.revParse(ctx, "refs/tags/"+tag)
+}
+
+// ListFiles implements Forge.
+func (l *LocalGit) ListFiles(ctx context.Context, sha string, dir string) ([]string, error) {
+	args := []string{"ls-tree", "-r", "-z", "--name-only", sha}
+	if dir != "" {
+		args = append(args, "--", dir)
+	}
+	out, err := l.git(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(out, "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// Download implements Forge.
+func (l *LocalGit) Download(ctx context.Context, sha string, path string, sha256 string, dest string) error {
+	blob, err := l.git(ctx, nil, "rev-parse", "--verify", "--quiet", sha+":"+path)
+	if err != nil {
+		return fmt.Errorf("unable to find %s at %s", path, sha)
+	}
+	size, err := l.git(ctx, nil, "cat-file", "-s", blob)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := download.CheckSize(n, download.DefaultLimits.MaxSize); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "cat-file", "blob", blob)
+	cmd.Dir = l.dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("unable to read %s at %s: %s", path, sha, strings.TrimSpace(stderr.String()))
+	}
+	return saveFile(path, content, blob, sha256, dest)
+}
+
+// revParse resolves rev to the SHA of the commit it points to.
+func (l *LocalGit) revParse(ctx context.Context, rev string) (string, error) {
+	return l.git(ctx, nil, "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+}
+
+// git runs a git command in the repository and returns its trimmed output.
+func (l *LocalGit) git(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = l.dir
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// MergedCommit implements Forge. The branch counts as merged once the base
+// contains it, the merge commit being the first commit of the base that
+// descends from it.
+func (l *LocalGit) MergedCommit(ctx context.Context, mr MergeRequest) (string, error) {
+	tip, err := l.revParse(ctx, "refs/heads/"+mr.Branch)
+	if err != nil {
+		return "", fmt.Errorf("unable to find branch %s: %w", mr.Branch, err)
+	}
+	base, err := l.revParse(ctx, "refs/heads/"+mr.Base)
+	if err != nil {
+		return "", fmt.Errorf("unable to find branch %s: %w", mr.Base, err)
+	}
+	if _, err := l.git(ctx, nil, "merge-base", "--is-ancestor", tip, base); err != nil {
+		return "", nil
+	}
+	descendants, err := l.git(ctx, nil, "rev-list", "--ancestry-path", "--reverse", tip+".."+base)
+	if err != nil {
+		return "", err
+	}
+	if descendants == "" {
+		// fast forward
+		return tip, nil
+	}
+	return strings.SplitN(descendants, "\n", 2)[0], nil
+}
+
+// CreateRelease implements Forge. The release of a local repository is an
+// annotated tag holding the name and body, assets are left where they are.
+func (l *LocalGit) CreateRelease(ctx context.Context, r Release) (string, error) {
+	if _, err := l.git(ctx, nil, "tag", "-a", r.Tag, "-m", r.Name+"\n\n"+r.Body, r.Commit); err != nil {
+		return "", err
+	}
+	for _, asset := range r.Assets {
+		fmt.Printf("Local repository: tags have no assets, %s is not attached\n", filepath.Base(asset))
+	}
+	return "", nil
+}
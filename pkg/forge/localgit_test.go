@@ -0,0 +1,139 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forge
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localRepo is the bare repository behind a LocalGit forge.
+type localRepo struct {
+	l        *LocalGit
+	releases int
+}
+
+// newLocalForge creates a bare repository whose main branch has a single
+// commit holding README.md, tagged v1.
+func newLocalForge(t *testing.T) testForge {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "repo.git")
+	work := filepath.Join(t.TempDir(), "work")
+	run := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	run(t.TempDir(), "init", "--quiet", "--bare", dir)
+	run(t.TempDir(), "clone", "--quiet", dir, work)
+	if err := os.WriteFile(filepath.Join(work, "README.md"), []byte("# repo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(work, "add", "README.md")
+	run(work, "commit", "--quiet", "-m", "init")
+	run(work, "tag", "-a", "-m", "v1", "v1")
+	run(work, "push", "--quiet", "origin", "HEAD:refs/heads/main", "v1")
+
+	l, err := NewLocalGit(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testForge{name: "local", forge: l, repo: &localRepo{l: l}}
+}
+
+func (r *localRepo) branch(t *testing.T, name string) string {
+	t.Helper()
+	sha, err := r.l.revParse(context.Background(), "refs/heads/"+name)
+	if err != nil {
+		return ""
+	}
+	return sha
+}
+
+func (r *localRepo) file(t *testing.T, sha string, path string) ([]byte, string, bool) {
+	t.Helper()
+	entry, err := r.l.git(context.Background(), nil, "ls-tree", sha, "--", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <mode> SP <type> SP <object> TAB <path>
+	fields := strings.Fields(entry)
+	if len(fields) < 3 {
+		return nil, "", false
+	}
+	cmd := exec.Command("git", "cat-file", "blob", fields[2])
+	cmd.Dir = r.l.dir
+	content, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content, fields[0], true
+}
+
+// release creates an annotated tag dated after every earlier one, the
+// latest release of a local repository being its newest tag.
+func (r *localRepo) release(t *testing.T, tag string, sha string) {
+	t.Helper()
+	r.releases++
+	date := fmt.Sprintf("GIT_COMMITTER_DATE=%d +0000", time.Now().Add(time.Duration(r.releases)*time.Hour).Unix())
+	env := []string{date, "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"}
+	if _, err := r.l.git(context.Background(), env, "tag", "-a", "-m", tag, tag, sha); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// merge records a merge commit of branch on top of base, as merging the
+// branch in a clone and pushing it would.
+func (r *localRepo) merge(t *testing.T, branch string, base string) string {
+	t.Helper()
+	ctx := context.Background()
+	tip, baseSHA := r.branch(t, branch), r.branch(t, base)
+	tree, err := r.l.git(ctx, nil, "rev-parse", tip+"^{tree}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com"}
+	sha, err := r.l.git(ctx, env, "commit-tree", tree, "-p", baseSHA, "-p", tip, "-m", "Merge branch "+branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.l.git(ctx, nil, "update-ref", "refs/heads/"+base, sha, baseSHA); err != nil {
+		t.Fatal(err)
+	}
+	return sha
+}
+
+func TestNewLocalGit(t *testing.T) {
+	if _, err := NewLocalGit(t.TempDir()); err == nil {
+		t.Error("NewLocalGit of a directory that is no repository succeeded")
+	}
+}
@@ -2,10 +2,15 @@ package githubapi
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/google/go-github/v35/github"
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/utils"
+	"net/http"
 	"os"
+	"path"
 	"strings"
 	"time"
 )
@@ -35,7 +40,8 @@ func GetRef(ctx context.Context, client *github.Client, sourceOwner string, sour
 }
 
 // GetTree generates the tree to commit based on the given files and the commit
-// of the ref you got in getRef.
+// of the ref you got in getRef. Each file is uploaded as a base64 blob, so
+// binary content arrives byte for byte, and keeps its executable bit.
 func GetTree(ctx context.Context, client *github.Client, ref *github.Reference, sourceFiles string, sourceOwner string,
 	sourceRepo string) (tree *github.Tree, err error) {
 	// Create a tree with what to commit.
@@ -48,7 +54,18 @@ func GetTree(ctx context.Context, client *github.Client, ref *github.Reference,
 		if err != nil {
 			return nil, err
 		}
-		entries = append(entries, &github.TreeEntry{Path: github.String(file), Type: github.String("blob"), Content: github.String(string(content)), Mode: github.String("100644")})
+		mode, err := utils.GitFileMode(strings.Split(fileArg, ":")[0])
+		if err != nil {
+			return nil, err
+		}
+		blob, _, err := client.Git.CreateBlob(ctx, sourceOwner, sourceRepo, &github.Blob{
+			Content:  github.String(base64.StdEncoding.EncodeToString(content)),
+			Encoding: github.String("base64"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to upload %s: %w", file, err)
+		}
+		entries = append(entries, &github.TreeEntry{Path: github.String(file), Type: github.String("blob"), SHA: blob.SHA, Mode: github.String(mode)})
 	}
 
 	tree, _, err = client.Git.CreateTree(ctx, sourceOwner, sourceRepo, *ref.Object.SHA, entries)
@@ -133,4 +150,152 @@ func CreatePR(ctx context.Context, client *github.Client, prRepoOwner string, pr
 
 	fmt.Printf("PR created: %s\n", pr.GetHTMLURL())
 	return nil
-}
\ No newline at end of file
+}
+
+// ResolveCommit resolves the revision to install to a full commit SHA. A
+// commit SHA wins over a ref (branch or tag name), which wins over a release
+// tag. The tag "latest" resolves to the tag of the latest release.
+func ResolveCommit(ctx context.Context, client *github.Client, sourceOwner string, sourceRepo string, tag string, ref string, commit string) (sha string, err error) {
+	switch {
+	case commit != "":
+		c, _, err := client.Repositories.GetCommit(ctx, sourceOwner, sourceRepo, commit)
+		if err != nil {
+			return "", fmt.Errorf("unable to find commit %s: %w", commit, err)
+		}
+		return c.GetSHA(), nil
+	case ref != "":
+		sha, _, err = client.Repositories.GetCommitSHA1(ctx, sourceOwner, sourceRepo, ref, "")
+		if err != nil {
+			return "", fmt.Errorf("unable to resolve ref %s: %w", ref, err)
+		}
+		return sha, nil
+	}
+
+	if tag == "" || tag == "latest" {
+		release, _, err := client.Repositories.GetLatestRelease(ctx, sourceOwner, sourceRepo)
+		if err != nil {
+			return "", fmt.Errorf("unable to find the latest release: %w", err)
+		}
+		tag = release.GetTagName()
+	}
+	return resolveTag(ctx, client, sourceOwner, sourceRepo, tag)
+}
+
+// resolveTag returns the commit a tag points to, peeling annotated tags.
+func resolveTag(ctx context.Context, client *github.Client, sourceOwner string, sourceRepo string, tag string) (string, error) {
+	tagRef, _, err := client.Git.GetRef(ctx, sourceOwner, sourceRepo, "tags/"+tag)
+	if err != nil {
+		return "", fmt.Errorf("unable to find tag %s: %w", tag, err)
+	}
+	object := tagRef.GetObject()
+	for object.GetType() == "tag" {
+		annotated, _, err := client.Git.GetTag(ctx, sourceOwner, sourceRepo, object.GetSHA())
+		if err != nil {
+			return "", fmt.Errorf("unable to read annotated tag %s: %w", tag, err)
+		}
+		object = annotated.GetObject()
+	}
+	if object.GetType() != "commit" {
+		return "", fmt.Errorf("tag %s does not point to a commit", tag)
+	}
+	return object.GetSHA(), nil
+}
+
+// ListFiles returns the paths of the files below dir in the tree of the
+// given commit, the whole tree when dir is empty. The tree SHA of dir comes
+// from the contents API listing of its parent. The recursive trees API
+// truncates large trees, those are walked one directory at a time instead.
+func ListFiles(ctx context.Context, client *github.Client, sourceOwner string, sourceRepo string, sha string, dir string) ([]string, error) {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	if dir == "" {
+		return listTree(ctx, client, sourceOwner, sourceRepo, sha, "")
+	}
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	_, entries, resp, err := client.Repositories.GetContents(ctx, sourceOwner, sourceRepo, parent, &github.RepositoryContentGetOptions{Ref: sha})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list %s at %s: %w", parent, sha, err)
+	}
+	for _, e := range entries {
+		if e.GetName() == path.Base(dir) && e.GetType() == "dir" {
+			return listTree(ctx, client, sourceOwner, sourceRepo, e.GetSHA(), dir+"/")
+		}
+	}
+	return nil, nil
+}
+
+// listTree lists the files of the tree treeSHA, prefixing their paths with
+// prefix.
+func listTree(ctx context.Context, client *github.Client, sourceOwner string, sourceRepo string, treeSHA string, prefix string) ([]string, error) {
+	tree, _, err := client.Git.GetTree(ctx, sourceOwner, sourceRepo, treeSHA, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tree %s: %w", treeSHA, err)
+	}
+	if tree.GetTruncated() {
+		if tree, _, err = client.Git.GetTree(ctx, sourceOwner, sourceRepo, treeSHA, false); err != nil {
+			return nil, fmt.Errorf("unable to read tree %s: %w", treeSHA, err)
+		}
+	}
+	var files []string
+	for _, e := range tree.Entries {
+		switch {
+		case e.GetType() == "blob":
+			files = append(files, prefix+e.GetPath())
+		case e.GetType() == "tree" && tree.GetTruncated():
+			sub, err := listTree(ctx, client, sourceOwner, sourceRepo, e.GetSHA(), prefix+e.GetPath()+"/")
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		}
+	}
+	return files, nil
+}
+
+// DownloadFile returns the content of the file at path as of the given
+// commit and its blob SHA. Small files come inline with the contents API
+// response, larger ones are fetched from their download URL through the
+// client, so the request is authenticated and goes to the same (enterprise)
+// host as the API.
+func DownloadFile(ctx context.Context, client *github.Client, sourceOwner string, sourceRepo string, path string, sha string) ([]byte, string, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, sourceOwner, sourceRepo, path, &github.RepositoryContentGetOptions{Ref: sha})
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to find %s at %s: %w", path, sha, err)
+	}
+	if file == nil {
+		return nil, "", fmt.Errorf("%s is not a file", path)
+	}
+	if err := download.CheckSize(int64(file.GetSize()), download.DefaultLimits.MaxSize); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	if file.GetEncoding() == "base64" {
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(content), file.GetSHA(), nil
+	}
+
+	content, err := download.Fetch(ctx, clientDoer{client}, file.GetDownloadURL(), nil, download.Digests{})
+	return content, file.GetSHA(), err
+}
+
+// clientDoer sends requests through the transport of a GitHub client.
+type clientDoer struct {
+	client *github.Client
+}
+
+func (d clientDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.BareDo(req.Context(), req)
+	if resp != nil {
+		// non 2xx responses are reported by the caller
+		return resp.Response, nil
+	}
+	return nil, err
+}
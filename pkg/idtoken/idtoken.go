@@ -0,0 +1,122 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idtoken finds OIDC identity tokens for signing without a browser:
+// tokens given on the command line, tokens CI systems provide to their
+// workloads, and the endpoints of the device code flow.
+package idtoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/download"
+)
+
+// EnvToken is the variable a pre-issued identity token is read from. GitLab
+// CI id_tokens and most other CI systems can be configured to set it.
+const EnvToken = "SIGSTORE_ID_TOKEN"
+
+// GitHub Actions exposes an endpoint for requesting identity tokens to jobs
+// with the id-token: write permission.
+const (
+	envGitHubRequestURL   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	envGitHubRequestToken = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+)
+
+// EnvNames are the variables Ambient reads. They grant identity tokens and
+// should not be handed to other programs.
+var EnvNames = []string{EnvToken, envGitHubRequestURL, envGitHubRequestToken}
+
+// Read returns the token value, which is either the path of a file holding
+// the token or the token itself.
+func Read(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if b, err := os.ReadFile(value); err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if strings.Count(value, ".") != 2 {
+		return "", errors.New("identity token is neither a JWT nor a readable file")
+	}
+	return value, nil
+}
+
+// Ambient returns the identity token the environment provides, and where it
+// came from. It returns an empty token when there is none.
+func Ambient(ctx context.Context, audience string) (token string, source string, err error) {
+	if t := os.Getenv(EnvToken); t != "" {
+		return t, EnvToken, nil
+	}
+	if reqURL, reqToken := os.Getenv(envGitHubRequestURL), os.Getenv(envGitHubRequestToken); reqURL != "" && reqToken != "" {
+		t, err := github(ctx, reqURL, reqToken, audience)
+		return t, "GitHub Actions", err
+	}
+	return "", "", nil
+}
+
+// github requests a token for audience from the GitHub Actions token
+// endpoint.
+func github(ctx context.Context, reqURL, reqToken, audience string) (string, error) {
+	u, err := url.Parse(reqURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("audience", audience)
+	u.RawQuery = q.Encode()
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+reqToken)
+	header.Set("Accept", "application/json")
+	b, err := download.Fetch(ctx, http.DefaultClient, u.String(), header, download.Digests{})
+	if err != nil {
+		return "", fmt.Errorf("unable to request an identity token from GitHub Actions: %w", err)
+	}
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return "", fmt.Errorf("unable to parse the GitHub Actions token response: %w", err)
+	}
+	if resp.Value == "" {
+		return "", errors.New("GitHub Actions returned an empty identity token")
+	}
+	return resp.Value, nil
+}
+
+// DeviceEndpoints discovers the device authorization and token endpoints of
+// issuer.
+func DeviceEndpoints(ctx context.Context, issuer string) (codeURL string, tokenURL string, err error) {
+	b, err := download.Fetch(ctx, http.DefaultClient, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil, download.Digests{})
+	if err != nil {
+		return "", "", fmt.Errorf("unable to discover the endpoints of %s: %w", issuer, err)
+	}
+	var config struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return "", "", fmt.Errorf("unable to parse the configuration of %s: %w", issuer, err)
+	}
+	if config.DeviceAuthorizationEndpoint == "" || config.TokenEndpoint == "" {
+		return "", "", fmt.Errorf("%s does not support the device code flow", issuer)
+	}
+	return config.DeviceAuthorizationEndpoint, config.TokenEndpoint, nil
+}
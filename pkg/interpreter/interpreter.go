@@ -0,0 +1,185 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package interpreter picks the program a signed script is run with.
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Default is the interpreter of scripts that do not declare one, which is
+// how sap ran every script before interpreters were recorded.
+const Default = "bash"
+
+// plainText is the mimetype of scripts without a shebang.
+const plainText = "text/plain; charset=utf-8"
+
+// Handler runs scripts of one kind.
+type Handler struct {
+	// Name is recorded in the manifest and used in allowlists.
+	Name string
+	// Command is the argv the script path is appended to.
+	Command []string
+	// Shebangs are the interpreter names in a #! line that select the handler.
+	// Names match exactly, versioned names are listed one by one so that
+	// python2.7 is never taken for python3.
+	Shebangs []string
+	// MimeTypes select the handler for scripts without a shebang.
+	MimeTypes []string
+	// Extensions select the handler for plain text scripts.
+	Extensions []string
+	// ByPath is set for interpreters that can not read the script from an
+	// open file and need its path.
+	ByPath bool
+}
+
+// Args returns the command line that runs script.
+func (h Handler) Args(script string) []string {
+	return append(append([]string{}, h.Command...), script)
+}
+
+// handlers is the registry of supported interpreters.
+var handlers = []Handler{
+	{Name: "bash", Command: []string{"bash"}, Shebangs: []string{"bash"}, Extensions: []string{".sh", ".bash"}},
+	{Name: "sh", Command: []string{"sh"}, Shebangs: []string{"sh", "dash", "ash"}},
+	{Name: "zsh", Command: []string{"zsh"}, Shebangs: []string{"zsh"}, Extensions: []string{".zsh"}},
+	{
+		Name:    "python3",
+		Command: []string{"python3"},
+		Shebangs: []string{
+			"python3", "python",
+			"python3.6", "python3.7", "python3.8", "python3.9", "python3.10",
+			"python3.11", "python3.12", "python3.13", "python3.14",
+		},
+		MimeTypes:  []string{"application/x-python"},
+		Extensions: []string{".py"},
+	},
+	{
+		Name:       "pwsh",
+		Command:    []string{"pwsh", "-NoProfile", "-NonInteractive", "-File"},
+		Shebangs:   []string{"pwsh"},
+		Extensions: []string{".ps1"},
+		// -File insists on a .ps1 extension
+		ByPath: true,
+	},
+}
+
+// Names lists the registered interpreters.
+func Names() []string {
+	var names []string
+	for _, h := range handlers {
+		names = append(names, h.Name)
+	}
+	return names
+}
+
+// Lookup returns the handler called name.
+func Lookup(name string) (Handler, error) {
+	for _, h := range handlers {
+		if h.Name == name {
+			return h, nil
+		}
+	}
+	return Handler{}, fmt.Errorf("unknown interpreter %s, supported interpreters: %s", name, strings.Join(Names(), ", "))
+}
+
+// Detect picks the handler for the script at file from its shebang, then its
+// mimetype, then its extension. Plain text scripts without any of those run
+// with the Default interpreter.
+func Detect(file string, content []byte) (Handler, error) {
+	if name, ok := shebang(content); ok {
+		for _, h := range handlers {
+			if contains(h.Shebangs, name) {
+				return h, nil
+			}
+		}
+		return Handler{}, fmt.Errorf("%s: unsupported interpreter %s", file, name)
+	}
+
+	mime := mimetype.Detect(content).String()
+	for _, h := range handlers {
+		if contains(h.MimeTypes, mime) {
+			return h, nil
+		}
+	}
+	if mime != plainText {
+		return Handler{}, fmt.Errorf("%s: unsupported mimetype %s", file, mime)
+	}
+	ext := path.Ext(file)
+	for _, h := range handlers {
+		if contains(h.Extensions, ext) {
+			return h, nil
+		}
+	}
+	return Lookup(Default)
+}
+
+// Check makes sure the interpreter is registered and on the allowlist. An
+// empty allowlist allows every registered interpreter.
+func Check(name string, allowed []string) (Handler, error) {
+	if name == "" {
+		name = Default
+	}
+	h, err := Lookup(name)
+	if err != nil {
+		return h, err
+	}
+	if len(allowed) > 0 && !contains(allowed, name) {
+		return h, fmt.Errorf("interpreter %s is not allowed, allowed interpreters: %s", name, strings.Join(allowed, ", "))
+	}
+	return h, nil
+}
+
+// shebang returns the interpreter named in the #! line of content, looking
+// through /usr/bin/env.
+func shebang(content []byte) (string, bool) {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return "", false
+	}
+	line, _ := bufio.NewReader(bytes.NewReader(content[2:])).ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	name := path.Base(fields[0])
+	if name == "env" {
+		name = ""
+		for _, f := range fields[1:] {
+			// skip env options such as -S and variable assignments
+			if strings.HasPrefix(f, "-") || strings.Contains(f, "=") {
+				continue
+			}
+			name = path.Base(f)
+			break
+		}
+	}
+	return name, name != ""
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package interpreter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+		err     bool
+	}{
+		{name: "env", file: "run", content: "#!/usr/bin/env python3\nprint(1)\n", want: "python3"},
+		{name: "env with options", file: "run", content: "#!/usr/bin/env -S PYTHONUNBUFFERED=1 python3 -u\n", want: "python3"},
+		{name: "bash", file: "run", content: "#!/bin/bash\necho hi\n", want: "bash"},
+		{name: "dash", file: "run", content: "#!/bin/dash\necho hi\n", want: "sh"},
+		{name: "versioned python", file: "run", content: "#!/usr/bin/python3.11\nprint(1)\n", want: "python3"},
+		{name: "space after #!", file: "run", content: "#! /bin/zsh -f\necho hi\n", want: "zsh"},
+		{name: "shebang over extension", file: "run.py", content: "#!/bin/sh\necho hi\n", want: "sh"},
+		{name: "spoofed bash", file: "run.sh", content: "#!/bin/bashx\necho hi\n", err: true},
+		{name: "python 2", file: "run", content: "#!/usr/bin/python2.7\nprint 1\n", err: true},
+		{name: "unknown", file: "run", content: "#!/usr/bin/perl\nprint 1;\n", err: true},
+		{name: "python extension", file: "setup.py", content: "import os\nprint(os.getcwd())\n", want: "python3"},
+		{name: "extension", file: "setup.zsh", content: "echo hi\n", want: "zsh"},
+		{name: "powershell extension", file: "setup.ps1", content: "Write-Host hi\n", want: "pwsh"},
+		{name: "plain text", file: "setup", content: "echo hi\n", want: Default},
+		// The mimetype wins over the extension
+		{name: "binary mimetype", file: "setup.sh", content: "\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00", err: true},
+		{name: "image mimetype", file: "setup.sh", content: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Detect(tt.file, []byte(tt.content))
+			if tt.err {
+				if err == nil {
+					t.Errorf("Detect = %s, want an error", h.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Name != tt.want {
+				t.Errorf("Detect = %s, want %s", h.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		want    string
+		err     string
+	}{
+		{name: "", want: "bash"},
+		{name: "python3", want: "python3"},
+		{name: "python3", allowed: []string{"bash", "python3"}, want: "python3"},
+		{name: "python3", allowed: []string{"bash", "sh"}, err: "not allowed"},
+		{name: "", allowed: []string{"sh"}, err: "not allowed"},
+		{name: "perl", err: "unknown interpreter"},
+	}
+	for _, tt := range tests {
+		h, err := Check(tt.name, tt.allowed)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Check(%q, %q) = %v, want an error containing %q", tt.name, tt.allowed, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Check(%q, %q): %v", tt.name, tt.allowed, err)
+			continue
+		}
+		if h.Name != tt.want {
+			t.Errorf("Check(%q, %q) = %s, want %s", tt.name, tt.allowed, h.Name, tt.want)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	h, err := Lookup("pwsh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"pwsh", "-NoProfile", "-NonInteractive", "-File", "setup.ps1"}
+	if got := h.Args("setup.ps1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Args = %q, want %q", got, want)
+	}
+	// The registry is not changed by building a command line
+	if got := h.Args("other.ps1"); got[len(got)-1] != "other.ps1" || len(got) != len(want) {
+		t.Errorf("Args = %q", got)
+	}
+}
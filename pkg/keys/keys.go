@@ -0,0 +1,263 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keys generates, encrypts and loads the long lived signing keys
+// used instead of keyless Fulcio certificates.
+//
+// Private keys are PKCS #8 encrypted with nacl/secretbox under a key derived
+// from a password with scrypt, in the same PEM format cosign uses, so keys
+// generated by either tool can be used by the other.
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported key algorithms.
+const (
+	ECDSAP256 = "ecdsa-p256"
+	Ed25519   = "ed25519"
+)
+
+const (
+	// PrivateKeyPEMType is the PEM type of encrypted private keys.
+	PrivateKeyPEMType = "ENCRYPTED SIGSTORE PRIVATE KEY"
+	// cosignPrivateKeyPEMType is the type older cosign releases write.
+	cosignPrivateKeyPEMType = "ENCRYPTED COSIGN PRIVATE KEY"
+	// PublicKeyPEMType is the PEM type of public keys.
+	PublicKeyPEMType = "PUBLIC KEY"
+)
+
+// scrypt parameters of newly encrypted keys.
+const (
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// encryptedKey is the JSON content of an encrypted private key PEM block.
+type encryptedKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Generate creates a key of the given algorithm.
+func Generate(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm %q, use %s or %s", algorithm, ECDSAP256, Ed25519)
+}
+
+// EncryptPrivateKey returns key as an encrypted PEM block.
+func EncryptPrivateKey(key crypto.Signer, password []byte) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var e encryptedKey
+	e.KDF.Name = "scrypt"
+	e.KDF.Params.N, e.KDF.Params.R, e.KDF.Params.P = scryptN, scryptR, scryptP
+	e.KDF.Salt = make([]byte, 32)
+	if _, err := rand.Read(e.KDF.Salt); err != nil {
+		return nil, err
+	}
+	secret, err := deriveKey(password, &e)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	e.Cipher.Name = "nacl/secretbox"
+	e.Cipher.Nonce = nonce[:]
+	e.Ciphertext = secretbox.Seal(nil, der, &nonce, secret)
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PrivateKeyPEMType, Bytes: b}), nil
+}
+
+// DecryptPrivateKey decrypts an encrypted PEM private key. Only ECDSA P-256
+// and Ed25519 keys are accepted.
+func DecryptPrivateKey(b []byte, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+	if block.Type != PrivateKeyPEMType && block.Type != cosignPrivateKeyPEMType {
+		return nil, fmt.Errorf("unsupported private key type %q, keys must be encrypted", block.Type)
+	}
+	var e encryptedKey
+	if err := json.Unmarshal(block.Bytes, &e); err != nil {
+		return nil, fmt.Errorf("unable to parse encrypted private key: %w", err)
+	}
+	if e.KDF.Name != "scrypt" || e.Cipher.Name != "nacl/secretbox" || len(e.Cipher.Nonce) != 24 {
+		return nil, fmt.Errorf("unsupported private key encryption %s with %s", e.Cipher.Name, e.KDF.Name)
+	}
+	secret, err := deriveKey(password, &e)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	copy(nonce[:], e.Cipher.Nonce)
+	der, ok := secretbox.Open(nil, e.Ciphertext, &nonce, secret)
+	if !ok {
+		return nil, errors.New("unable to decrypt private key, wrong password")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if err := checkPublicKey(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// deriveKey derives the secretbox key from password with the scrypt
+// parameters and salt of e.
+func deriveKey(password []byte, e *encryptedKey) (*[32]byte, error) {
+	b, err := scrypt.Key(password, e.KDF.Salt, e.KDF.Params.N, e.KDF.Params.R, e.KDF.Params.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	var secret [32]byte
+	copy(secret[:], b)
+	return &secret, nil
+}
+
+// LoadPrivateKey reads and decrypts the private key in file.
+func LoadPrivateKey(file string, password []byte) (crypto.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := DecryptPrivateKey(b, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return key, nil
+}
+
+// MarshalPublicKey returns the PEM encoding of pub.
+func MarshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PublicKeyPEMType, Bytes: der}), nil
+}
+
+// ParsePublicKey decodes a PEM public key. Only ECDSA P-256 and Ed25519
+// keys are accepted.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != PublicKeyPEMType {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPublicKey(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// LoadPublicKey reads the PEM public key in file.
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return pub, nil
+}
+
+// ID returns the fingerprint of pub, the sha256 of its DER encoding. It
+// stands in for the signer identity of scripts signed with the key.
+func ID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Sign signs payload with key. ECDSA keys sign the sha256 digest of the
+// payload, Ed25519 keys sign the payload itself.
+func Sign(key crypto.Signer, payload []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func checkPublicKey(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return nil
+		}
+		return fmt.Errorf("unsupported ECDSA curve %s, use P-256", k.Curve.Params().Name)
+	}
+	return fmt.Errorf("unsupported key type %T, use ECDSA P-256 or Ed25519", pub)
+}
@@ -0,0 +1,178 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// verify checks a signature made by Sign.
+func verify(t *testing.T, pub crypto.PublicKey, payload, sig []byte) bool {
+	t.Helper()
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	}
+	t.Fatalf("unexpected key type %T", pub)
+	return false
+}
+
+func TestGenerateEncryptDecrypt(t *testing.T) {
+	password := []byte("correct horse")
+	for _, algorithm := range []string{ECDSAP256, Ed25519} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := Generate(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch key.(type) {
+			case *ecdsa.PrivateKey:
+				if algorithm != ECDSAP256 {
+					t.Errorf("Generate(%s) returned an ECDSA key", algorithm)
+				}
+			case ed25519.PrivateKey:
+				if algorithm != Ed25519 {
+					t.Errorf("Generate(%s) returned an Ed25519 key", algorithm)
+				}
+			default:
+				t.Fatalf("Generate(%s) returned %T", algorithm, key)
+			}
+
+			encrypted, err := EncryptPrivateKey(key, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(encrypted); block == nil || block.Type != PrivateKeyPEMType {
+				t.Fatalf("EncryptPrivateKey wrote %q", encrypted)
+			}
+			decrypted, err := DecryptPrivateKey(encrypted, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decrypted.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()) {
+				t.Error("the decrypted key differs from the generated one")
+			}
+			if _, err := DecryptPrivateKey(encrypted, []byte("wrong")); err == nil || !strings.Contains(err.Error(), "wrong password") {
+				t.Errorf("DecryptPrivateKey with the wrong password = %v, want a wrong password error", err)
+			}
+
+			// The public key round trips and verifies what the key signs
+			pubPEM, err := MarshalPublicKey(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, err := ParsePublicKey(pubPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := Sign(decrypted, []byte("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verify(t, pub, []byte("payload"), sig) {
+				t.Error("the signature does not verify")
+			}
+			if verify(t, pub, []byte("other"), sig) {
+				t.Error("the signature verifies another payload")
+			}
+			id, err := ID(pub)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if other, _ := ID(key.Public()); id != other || !strings.HasPrefix(id, "sha256:") {
+				t.Errorf("ID = %s and %s", id, other)
+			}
+		})
+	}
+	if _, err := Generate("rsa"); err == nil {
+		t.Error("Generate(rsa) succeeded")
+	}
+}
+
+// testdata/cosign.key is an ECDSA P-256 key in the encrypted format and PEM
+// type of cosign generate-key-pair, with password hello, and cosign.pub its
+// public key.
+func TestDecryptCosignKey(t *testing.T) {
+	key, err := LoadPrivateKey(filepath.Join("testdata", "cosign.key"), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := LoadPublicKey(filepath.Join("testdata", "cosign.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(key.Public()) {
+		t.Error("the key does not match cosign.pub")
+	}
+	if _, err := LoadPrivateKey(filepath.Join("testdata", "cosign.key"), []byte("hell")); err == nil {
+		t.Error("LoadPrivateKey with the wrong password succeeded")
+	}
+}
+
+func TestRejectedKeys(t *testing.T) {
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []crypto.Signer{p384, r} {
+		encrypted, err := EncryptPrivateKey(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecryptPrivateKey(encrypted, nil); err == nil {
+			t.Errorf("DecryptPrivateKey of a %T key succeeded", key)
+		}
+		pubPEM, err := MarshalPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParsePublicKey(pubPEM); err == nil {
+			t.Errorf("ParsePublicKey of a %T key succeeded", key.Public())
+		}
+	}
+
+	// Unencrypted keys are refused
+	key, err := Generate(ECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := DecryptPrivateKey(plain, nil); err == nil {
+		t.Error("DecryptPrivateKey of an unencrypted key succeeded")
+	}
+}
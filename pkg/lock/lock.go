@@ -0,0 +1,178 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lock reads and writes sap.lock, which pins every script a
+// project depends on to the commit, content and signer it was verified at.
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/manifest"
+)
+
+const (
+	// Version is the lock file format written by this version of sap.
+	Version = 1
+	// FileName is the default name of the lock file.
+	FileName = "sap.lock"
+)
+
+// Lock lists the locked scripts.
+type Lock struct {
+	Version int      `json:"version"`
+	Scripts []Script `json:"scripts"`
+}
+
+// Script pins one script of a repository.
+type Script struct {
+	Forge    string `json:"forge"`
+	ForgeURL string `json:"forgeUrl,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Repo     string `json:"repo,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	// Tag and Ref are the revision that was asked for, sap lock --update
+	// resolves them again. Without either the commit is pinned for good.
+	Tag string `json:"tag,omitempty"`
+	Ref string `json:"ref,omitempty"`
+
+	Path     string          `json:"path"`
+	Commit   string          `json:"commit"`
+	SHA256   string          `json:"sha256"`
+	Signer   manifest.Signer `json:"signer"`
+	LogIndex int64           `json:"logIndex"`
+}
+
+// NewScript returns the lock entry of the script at path in the repository
+// at loc.
+func NewScript(loc forge.Location, path string) Script {
+	return Script{
+		Forge:    loc.Kind,
+		ForgeURL: loc.BaseURL,
+		Owner:    loc.Owner,
+		Repo:     loc.Repo,
+		Dir:      loc.Dir,
+		Path:     path,
+	}
+}
+
+// Location is the repository the script is read from.
+func (s *Script) Location() forge.Location {
+	return forge.Location{Kind: s.Forge, BaseURL: s.ForgeURL, Owner: s.Owner, Repo: s.Repo, Dir: s.Dir}
+}
+
+// String names the script, for example github.com/owner/repo:setup.sh.
+func (s *Script) String() string {
+	return s.Location().ID() + ":" + s.Path
+}
+
+// Diff lists what changed from s to other, one line per field.
+func (s *Script) Diff(other *Script) []string {
+	var changes []string
+	change := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", field, from, to))
+		}
+	}
+	change("commit", s.Commit, other.Commit)
+	change("sha256", s.SHA256, other.SHA256)
+	change("signer", signer(s.Signer), signer(other.Signer))
+	change("log index", fmt.Sprint(s.LogIndex), fmt.Sprint(other.LogIndex))
+	return changes
+}
+
+// Check fails when got deviates from the locked script s.
+func (s *Script) Check(got *Script) error {
+	if changes := s.Diff(got); len(changes) > 0 {
+		return fmt.Errorf("%s deviates from the lock file: %s", s, strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+func signer(s manifest.Signer) string {
+	return strings.Join(s.Identities, ",") + " (" + s.Issuer + ")"
+}
+
+// Load reads a lock file.
+func Load(file string) (*Lock, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var l Lock
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if l.Version > Version {
+		return nil, fmt.Errorf("lock file version %d is newer than the supported version %d, upgrade sap", l.Version, Version)
+	}
+	return &l, nil
+}
+
+// Write writes the lock file, with the scripts in a stable order so it
+// diffs well.
+func (l *Lock) Write(file string) error {
+	l.Version = Version
+	sort.Slice(l.Scripts, func(i, j int) bool { return l.Scripts[i].String() < l.Scripts[j].String() })
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0644)
+}
+
+// Find returns the locked script of the repository repoID, see
+// forge.Location.ID, named by path or, when unambiguous, by file name.
+// Without a name the repository must have a single locked script. It
+// returns nil when nothing matches.
+func (l *Lock) Find(repoID, name string) (*Script, error) {
+	var found []*Script
+	for i := range l.Scripts {
+		s := &l.Scripts[i]
+		if s.Location().ID() != repoID {
+			continue
+		}
+		if s.Path == name {
+			return s, nil
+		}
+		if name == "" || path.Base(s.Path) == name {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%d scripts of %s are locked, name the one to use", len(found), repoID)
+}
+
+// Put adds s, replacing the entry of the same script.
+func (l *Lock) Put(s Script) {
+	for i := range l.Scripts {
+		if l.Scripts[i].String() == s.String() {
+			l.Scripts[i] = s
+			return
+		}
+	}
+	l.Scripts = append(l.Scripts, s)
+}
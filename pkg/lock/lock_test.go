@@ -0,0 +1,179 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/forge"
+	"github.com/lukehinds/sap/pkg/manifest"
+)
+
+var testLocation = forge.Location{Kind: forge.KindGitHub, Owner: "jdoe", Repo: "scripts"}
+
+func lockedScript(path string) Script {
+	s := NewScript(testLocation, path)
+	s.Tag = "v1"
+	s.Commit = strings.Repeat("a", 40)
+	s.SHA256 = strings.Repeat("b", 64)
+	s.Signer = manifest.Signer{Identities: []string{"ci@example.com"}, Issuer: "https://token.actions.githubusercontent.com"}
+	s.LogIndex = 42
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *Script)
+		want   []string
+	}{
+		{name: "same", change: func(s *Script) {}},
+		{
+			name:   "revision asked for",
+			change: func(s *Script) { s.Tag, s.Ref = "", "main" },
+		},
+		{
+			name:   "commit",
+			change: func(s *Script) { s.Commit = strings.Repeat("c", 40) },
+			want:   []string{"commit: " + strings.Repeat("a", 40) + " -> " + strings.Repeat("c", 40)},
+		},
+		{
+			name:   "content",
+			change: func(s *Script) { s.SHA256 = strings.Repeat("d", 64) },
+			want:   []string{"sha256: " + strings.Repeat("b", 64) + " -> " + strings.Repeat("d", 64)},
+		},
+		{
+			name:   "signer identity",
+			change: func(s *Script) { s.Signer.Identities = []string{"someone@example.com"} },
+			want:   []string{"signer: ci@example.com (https://token.actions.githubusercontent.com) -> someone@example.com (https://token.actions.githubusercontent.com)"},
+		},
+		{
+			name:   "signer issuer",
+			change: func(s *Script) { s.Signer.Issuer = "https://accounts.google.com" },
+			want:   []string{"signer: ci@example.com (https://token.actions.githubusercontent.com) -> ci@example.com (https://accounts.google.com)"},
+		},
+		{
+			name: "log entry and commit",
+			change: func(s *Script) {
+				s.LogIndex = 43
+				s.Commit = strings.Repeat("c", 40)
+			},
+			want: []string{"commit: " + strings.Repeat("a", 40) + " -> " + strings.Repeat("c", 40), "log index: 42 -> 43"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locked := lockedScript("install.sh")
+			got := lockedScript("install.sh")
+			tt.change(&got)
+			if changes := locked.Diff(&got); !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("Diff = %q, want %q", changes, tt.want)
+			}
+			err := locked.Check(&got)
+			if (err != nil) != (len(tt.want) > 0) {
+				t.Errorf("Check = %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "github.com/jdoe/scripts:install.sh deviates") {
+				t.Errorf("Check does not name the script: %v", err)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	other := lockedScript("install.sh")
+	other.Owner = "other"
+	l := &Lock{Scripts: []Script{
+		lockedScript("install.sh"),
+		lockedScript("tools/setup.sh"),
+		lockedScript("ci/setup.sh"),
+		other,
+	}}
+	single := &Lock{Scripts: []Script{lockedScript("install.sh")}}
+
+	tests := []struct {
+		name   string
+		lock   *Lock
+		repoID string
+		script string
+		want   string
+		err    bool
+	}{
+		{name: "path", lock: l, repoID: "github.com/jdoe/scripts", script: "tools/setup.sh", want: "tools/setup.sh"},
+		{name: "file name", lock: l, repoID: "github.com/jdoe/scripts", script: "install.sh", want: "install.sh"},
+		{name: "ambiguous file name", lock: l, repoID: "github.com/jdoe/scripts", script: "setup.sh", err: true},
+		{name: "no name, several scripts", lock: l, repoID: "github.com/jdoe/scripts", err: true},
+		{name: "no name, single script", lock: single, repoID: "github.com/jdoe/scripts", want: "install.sh"},
+		{name: "other repository", lock: l, repoID: "github.com/other/scripts", script: "install.sh", want: "install.sh"},
+		{name: "unknown repository", lock: l, repoID: "gitlab.com/jdoe/scripts", script: "install.sh"},
+		{name: "unknown script", lock: l, repoID: "github.com/jdoe/scripts", script: "missing.sh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := tt.lock.Find(tt.repoID, tt.script)
+			if tt.err {
+				if err == nil {
+					t.Errorf("Find = %v, want an error", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == "" && s != nil:
+				t.Errorf("Find = %s, want nothing", s)
+			case tt.want != "" && (s == nil || s.Path != tt.want || s.Location().ID() != tt.repoID):
+				t.Errorf("Find = %v, want %s:%s", s, tt.repoID, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), FileName)
+	l := &Lock{}
+	l.Put(lockedScript("tools/setup.sh"))
+	l.Put(lockedScript("install.sh"))
+	updated := lockedScript("install.sh")
+	updated.LogIndex = 43
+	l.Put(updated)
+	if err := l.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != Version || len(got.Scripts) != 2 {
+		t.Fatalf("Load = %+v", got)
+	}
+	if got.Scripts[0].Path != "install.sh" || got.Scripts[0].LogIndex != 43 {
+		t.Errorf("Load = %+v, want the updated install.sh first", got.Scripts)
+	}
+
+	newer := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(newer, []byte(`{"version":2,"scripts":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(newer); err == nil {
+		t.Error("Load of a newer lock file succeeded")
+	}
+}
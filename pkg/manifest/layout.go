@@ -0,0 +1,79 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"path"
+	"strings"
+)
+
+// Layout of the signed materials in the repository. Each script has its own
+// directory, named after the script path below Root, holding its manifest
+// and materials under fixed names:
+//
+//	.sap/<script-path>/sap-manifest.json
+//	.sap/<script-path>/sap-manifest.sig
+//	.sap/<script-path>/sig
+//	.sap/<script-path>/cert       keyless only
+//	.sap/<script-path>/chain      keyless only
+//	.sap/<script-path>/key.pub    key only
+//	.sap/<script-path>/rekor.json
+//
+// Signing a script again replaces its materials in place, and the manifest
+// of a script is found from its path at any revision.
+const (
+	// Root is the directory holding the materials of every script.
+	Root = ".sap"
+	// LegacyRoot held a directory per signing run before the layout.
+	LegacyRoot = ".sigstore"
+	// SignatureName is the base64 signature of the script.
+	SignatureName = "sig"
+	// CertificateName is the Fulcio signing certificate.
+	CertificateName = "cert"
+	// ChainName is the certificate chain Fulcio returned.
+	ChainName = "chain"
+	// PublicKeyName is the public key of scripts signed with a key.
+	PublicKeyName = "key.pub"
+	// RekorName is the Rekor entry with its inclusion proof.
+	RekorName = "rekor.json"
+)
+
+// MaterialNames are the names of all materials stored in a script directory.
+var MaterialNames = []string{FileName, SignatureFileName, SignatureName, CertificateName, ChainName, PublicKeyName, RekorName}
+
+// Dir returns the directory of the materials of the script at path p. The
+// path never leaves Root.
+func Dir(p string) string {
+	return path.Join(Root, strings.TrimPrefix(path.Clean("/"+p), "/"))
+}
+
+// Path returns the path of the manifest of the script at path p.
+func Path(p string) string {
+	return path.Join(Dir(p), FileName)
+}
+
+// ScriptPath returns the path of the script whose manifest is at
+// manifestPath, and false if manifestPath is not part of the layout.
+func ScriptPath(manifestPath string) (string, bool) {
+	if path.Base(manifestPath) != FileName {
+		return "", false
+	}
+	dir := path.Dir(manifestPath)
+	if !strings.HasPrefix(dir, Root+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(dir, Root+"/"), true
+}
@@ -0,0 +1,156 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/lukehinds/sap/pkg/sandbox"
+
+	jsoncanonicalizer "github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+)
+
+const (
+	// SchemaVersion is the manifest format written by this version of sap.
+	// Version 2 added the interpreter of each artifact, version 3 the
+	// signing mode.
+	SchemaVersion = 3
+	// FileName is the name of the manifest within the signed materials.
+	FileName = "sap-manifest.json"
+	// SignatureFileName is the name of the manifest signature, stored next
+	// to the manifest.
+	SignatureFileName = "sap-manifest.sig"
+)
+
+// Signing modes.
+const (
+	// ModeKeyless scripts are signed with an ephemeral key certified by
+	// Fulcio. Manifests without a mode are keyless.
+	ModeKeyless = "keyless"
+	// ModeKey scripts are signed with a long lived key, installers verify
+	// them with its configured public key.
+	ModeKey = "key"
+)
+
+// Manifest links every signed script to the materials needed to verify it.
+// All paths are relative to the root of the repository.
+type Manifest struct {
+	SchemaVersion int        `json:"schemaVersion"`
+	Artifacts     []Artifact `json:"artifacts"`
+}
+
+// Artifact is a signed script and its signing materials.
+type Artifact struct {
+	Path      string `json:"path"`
+	SHA256    string `json:"sha256"`
+	Signature string `json:"signature"`
+	// Mode is how the script was signed, ModeKeyless or ModeKey.
+	Mode        string `json:"mode,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Chain       string `json:"chain,omitempty"`
+	// PublicKey is the public key of ModeKey scripts. It is informational,
+	// installers only trust the key they are configured with.
+	PublicKey string `json:"publicKey,omitempty"`
+	Rekor     Rekor  `json:"rekor"`
+	Signer    Signer `json:"signer"`
+	// Interpreter is the handler the signer intended the script to run
+	// with. Version 1 manifests have none and run scripts with bash.
+	Interpreter string `json:"interpreter,omitempty"`
+	// Sandbox is the policy the signer declared for running the script
+	// with --sandbox. Installers can only narrow it.
+	Sandbox *sandbox.Policy `json:"sandbox,omitempty"`
+}
+
+// Rekor points to the transparency log entry of an artifact.
+type Rekor struct {
+	Entry    string `json:"entry"`
+	UUID     string `json:"uuid"`
+	LogIndex int64  `json:"logIndex"`
+}
+
+// Signer is the identity that signed an artifact.
+type Signer struct {
+	Identities []string `json:"identities"`
+	Issuer     string   `json:"issuer,omitempty"`
+}
+
+// Marshal returns the canonical (RFC 8785) JSON encoding of the manifest,
+// which is the form that gets signed.
+func Marshal(m *Manifest) ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return jsoncanonicalizer.Transform(b)
+}
+
+// Parse decodes a manifest and checks that its schema version is supported.
+func Parse(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest: %w", err)
+	}
+	switch {
+	case m.SchemaVersion == 0:
+		return nil, errors.New("manifest has no schema version")
+	case m.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("manifest schema version %d is newer than the supported version %d, upgrade sap", m.SchemaVersion, SchemaVersion)
+	}
+	for _, a := range m.Artifacts {
+		if a.Mode != "" && a.Mode != ModeKeyless && a.Mode != ModeKey {
+			return nil, fmt.Errorf("%s: unknown signing mode %q", a.Path, a.Mode)
+		}
+		if a.Sandbox == nil {
+			continue
+		}
+		if err := a.Sandbox.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", a.Path, err)
+		}
+	}
+	return &m, nil
+}
+
+// Read loads and parses the manifest in file.
+func Read(file string) (*Manifest, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Write stores the canonical encoding of the manifest in file.
+func Write(file string, m *Manifest) error {
+	b, err := Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// Find returns the artifact for the script at path, or nil if the manifest
+// has no such artifact.
+func (m *Manifest) Find(path string) *Artifact {
+	for i := range m.Artifacts {
+		if m.Artifacts[i].Path == path {
+			return &m.Artifacts[i]
+		}
+	}
+	return nil
+}
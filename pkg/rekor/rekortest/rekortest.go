@@ -0,0 +1,316 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rekortest provides a Rekor stand-in for tests: an in-memory
+// transparency log that signs entries and tree heads with its own key and
+// serves inclusion and consistency proofs over the Rekor v1 API.
+package rekortest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoncanonicalizer "github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	"github.com/go-openapi/swag"
+	"github.com/google/trillian/merkle/rfc6962/hasher"
+	"github.com/google/trillian/types"
+	"github.com/sigstore/rekor/pkg/generated/models"
+)
+
+// Server is a running Rekor stand-in.
+type Server struct {
+	URL string
+	Key *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	logID   string
+	leaves  [][]byte
+	entries map[string]*entry
+}
+
+type entry struct {
+	body           []byte
+	index          int64
+	integratedTime int64
+}
+
+// NewServer starts a Rekor stand-in that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	s := &Server{
+		Key:     key,
+		logID:   hex.EncodeToString(logID[:]),
+		entries: map[string]*entry{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/log/entries", s.createEntry)
+	mux.HandleFunc("/api/v1/log/entries/", s.getEntry)
+	mux.HandleFunc("/api/v1/log/proof", s.getProof)
+	mux.HandleFunc("/api/v1/log", s.getLogInfo)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	s.URL = srv.URL
+	return s
+}
+
+// PublicKeyPEM returns the PEM encoded public key of the log.
+func (s *Server) PublicKeyPEM() []byte {
+	der, _ := x509.MarshalPKIXPublicKey(s.Key.Public())
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// createEntry records a rekord entry, keeping the hash of the data in place
+// of its content as Rekor does.
+func (s *Server) createEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var proposed struct {
+		Spec models.RekordV001Schema `json:"spec"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&proposed); err != nil || proposed.Spec.Data == nil {
+		writeError(w, http.StatusBadRequest, "invalid rekord entry")
+		return
+	}
+	spec := proposed.Spec
+	digest := sha256.Sum256(spec.Data.Content)
+	spec.Data = &models.RekordV001SchemaData{
+		Hash: &models.RekordV001SchemaDataHash{
+			Algorithm: swag.String(models.RekordV001SchemaDataHashAlgorithmSha256),
+			Value:     swag.String(hex.EncodeToString(digest[:])),
+		},
+	}
+	b, err := json.Marshal(map[string]interface{}{"apiVersion": "0.0.1", "kind": "rekord", "spec": spec})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	body, err := jsoncanonicalizer.Transform(b)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	uuid := hex.EncodeToString(hasher.DefaultHasher.HashLeaf(body))
+	e, exists := s.entries[uuid]
+	if !exists {
+		e = &entry{body: body, index: int64(len(s.leaves)), integratedTime: time.Now().Unix()}
+		s.entries[uuid] = e
+		s.leaves = append(s.leaves, body)
+	}
+	anon := s.logEntry(e, false)
+	s.mu.Unlock()
+
+	w.Header().Set("Location", "/api/v1/log/entries/"+uuid)
+	if exists {
+		writeError(w, http.StatusConflict, "an equivalent entry already exists in the transparency log")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]models.LogEntryAnon{uuid: anon})
+}
+
+// getEntry returns an entry with its signed entry timestamp and the proof
+// of its inclusion in the current tree.
+func (s *Server) getEntry(w http.ResponseWriter, r *http.Request) {
+	uuid := strings.TrimPrefix(r.URL.Path, "/api/v1/log/entries/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[uuid]
+	if !ok {
+		writeError(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]models.LogEntryAnon{uuid: s.logEntry(e, true)})
+}
+
+// getLogInfo returns the signed tree head of the log.
+func (s *Server) getLogInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	size := int64(len(s.leaves))
+	root := treeHash(s.leaves)
+	s.mu.Unlock()
+
+	logRoot, err := (&types.LogRootV1{
+		TreeSize:       uint64(size),
+		RootHash:       root,
+		TimestampNanos: uint64(time.Now().UnixNano()),
+	}).MarshalBinary()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sig, err := s.sign(logRoot)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rootHash": hex.EncodeToString(root),
+		"treeSize": size,
+		"signedTreeHead": map[string][]byte{
+			"keyHint":   []byte(s.logID),
+			"logRoot":   logRoot,
+			"signature": sig,
+		},
+	})
+}
+
+// getProof returns the consistency proof between two tree sizes.
+func (s *Server) getProof(w http.ResponseWriter, r *http.Request) {
+	first, err1 := strconv.Atoi(r.URL.Query().Get("firstSize"))
+	last, err2 := strconv.Atoi(r.URL.Query().Get("lastSize"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err1 != nil || err2 != nil || first < 1 || first > last || last > len(s.leaves) {
+		writeError(w, http.StatusBadRequest, "invalid tree sizes")
+		return
+	}
+	leaves := s.leaves[:last]
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"rootHash": hex.EncodeToString(treeHash(leaves)),
+		"hashes":   encodeHashes(subproof(first, leaves, true)),
+	})
+}
+
+// logEntry returns the API form of e, signed with the log key. The caller
+// holds s.mu.
+func (s *Server) logEntry(e *entry, withProof bool) models.LogEntryAnon {
+	anon := models.LogEntryAnon{
+		Body:           base64.StdEncoding.EncodeToString(e.body),
+		IntegratedTime: swag.Int64(e.integratedTime),
+		LogID:          swag.String(s.logID),
+		LogIndex:       swag.Int64(e.index),
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"body":           anon.Body,
+		"integratedTime": e.integratedTime,
+		"logIndex":       e.index,
+		"logID":          s.logID,
+	})
+	canonical, _ := jsoncanonicalizer.Transform(payload)
+	set, _ := s.sign(canonical)
+	anon.Verification = &models.LogEntryAnonVerification{SignedEntryTimestamp: set}
+	if withProof {
+		size := int64(len(s.leaves))
+		anon.Verification.InclusionProof = &models.InclusionProof{
+			LogIndex: swag.Int64(e.index),
+			TreeSize: swag.Int64(size),
+			RootHash: swag.String(hex.EncodeToString(treeHash(s.leaves))),
+			Hashes:   encodeHashes(path(int(e.index), s.leaves)),
+		}
+	}
+	return anon
+}
+
+func (s *Server) sign(b []byte) ([]byte, error) {
+	digest := sha256.Sum256(b)
+	return ecdsa.SignASN1(rand.Reader, s.Key, digest[:])
+}
+
+// treeHash is the RFC 6962 merkle tree hash of leaves.
+func treeHash(leaves [][]byte) []byte {
+	switch n := len(leaves); n {
+	case 0:
+		return hasher.DefaultHasher.EmptyRoot()
+	case 1:
+		return hasher.DefaultHasher.HashLeaf(leaves[0])
+	default:
+		k := split(n)
+		return hasher.DefaultHasher.HashChildren(treeHash(leaves[:k]), treeHash(leaves[k:]))
+	}
+}
+
+// path is the RFC 6962 audit path of leaf m.
+func path(m int, leaves [][]byte) [][]byte {
+	n := len(leaves)
+	if n <= 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(path(m, leaves[:k]), treeHash(leaves[k:]))
+	}
+	return append(path(m-k, leaves[k:]), treeHash(leaves[:k]))
+}
+
+// subproof is the RFC 6962 consistency proof from the tree of the first m
+// leaves.
+func subproof(m int, leaves [][]byte, complete bool) [][]byte {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{treeHash(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), treeHash(leaves[k:]))
+	}
+	return append(subproof(m-k, leaves[k:], false), treeHash(leaves[:k]))
+}
+
+// split returns the largest power of two smaller than n.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	out := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		out = append(out, hex.EncodeToString(h))
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]interface{}{"code": code, "message": msg})
+}
@@ -0,0 +1,115 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sandbox runs scripts with a read-only view of the system.
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initArg is the argv[0] sap is re-executed with to set up a sandbox.
+const initArg = "sap-sandbox-init"
+
+// Policy is what a sandboxed script may do. The zero Policy allows no
+// network, no writes outside a private /tmp and sets no resource limits.
+type Policy struct {
+	Network  bool     `json:"network,omitempty"`
+	Writable []string `json:"writable,omitempty"`
+	Limits   Limits   `json:"limits,omitempty"`
+}
+
+// Limits cap the resources of a sandboxed script, zero means no limit.
+type Limits struct {
+	CPUSeconds uint64 `json:"cpuSeconds,omitempty"`
+	MemoryMB   uint64 `json:"memoryMB,omitempty"`
+	Processes  uint64 `json:"processes,omitempty"`
+	FileSizeMB uint64 `json:"fileSizeMB,omitempty"`
+}
+
+// Restrictions narrow the policy a signer declared. They can only take
+// permissions away.
+type Restrictions struct {
+	NoNetwork bool
+	// Writable keeps only the declared paths within one of these. Nil keeps
+	// none, the installer has to opt in to the writes a signer declares.
+	Writable []string
+	Limits   Limits
+}
+
+// Validate checks that the writable paths are absolute.
+func (p Policy) Validate() error {
+	for _, w := range p.Writable {
+		if !filepath.IsAbs(w) {
+			return fmt.Errorf("sandbox writable path %s is not absolute", w)
+		}
+	}
+	return nil
+}
+
+// Restrict returns the policy narrowed by r.
+func (p Policy) Restrict(r Restrictions) Policy {
+	out := Policy{
+		Network: p.Network && !r.NoNetwork,
+		Limits: Limits{
+			CPUSeconds: lower(p.Limits.CPUSeconds, r.Limits.CPUSeconds),
+			MemoryMB:   lower(p.Limits.MemoryMB, r.Limits.MemoryMB),
+			Processes:  lower(p.Limits.Processes, r.Limits.Processes),
+			FileSizeMB: lower(p.Limits.FileSizeMB, r.Limits.FileSizeMB),
+		},
+	}
+	for _, w := range p.Writable {
+		if within(w, r.Writable) {
+			out.Writable = append(out.Writable, filepath.Clean(w))
+		}
+	}
+	return out
+}
+
+// Cmd is a command to run in a sandbox.
+type Cmd struct {
+	// Args is the full command line.
+	Args []string
+	// Script is made visible inside the sandbox even when it lives in /tmp.
+	Script string
+	// ScriptFile, when set, is readable by the command as /dev/fd/3.
+	ScriptFile *os.File `json:"-"`
+	Env        []string
+	Dir        string
+	Policy     Policy
+}
+
+// lower returns the smaller of two limits where zero means unlimited.
+func lower(a, b uint64) uint64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// within reports whether file is one of dirs or below one of them.
+func within(file string, dirs []string) bool {
+	file = filepath.Clean(file)
+	for _, d := range dirs {
+		d = filepath.Clean(d)
+		if file == d || strings.HasPrefix(file, strings.TrimSuffix(d, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
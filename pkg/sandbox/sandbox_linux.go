@@ -0,0 +1,339 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// childConfig is handed to the re-executed sap over a pipe.
+type childConfig struct {
+	Cmd  Cmd
+	Root string
+	Cwd  string
+}
+
+// Run starts sap again in new user, mount, pid, uts, ipc and, unless the
+// policy allows network, network namespaces. That process builds the
+// read-only view of the system, applies the limits and the seccomp filter
+// and then executes the command.
+func (c *Cmd) Run() error {
+	if auditArch == 0 {
+		return errors.New("--sandbox is not supported on " + runtime.GOARCH)
+	}
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if c.Dir != "" {
+		cwd = c.Dir
+	}
+	root, err := os.MkdirTemp("", "sap-sandbox-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(root)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	if !c.Policy.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = []string{initArg}
+	cmd.Env = c.Env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{r}
+	if c.ScriptFile != nil {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, c.ScriptFile)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+		// keep the uid and only carry over what is needed to set up mounts,
+		// it is dropped again before the script runs
+		AmbientCaps: []uintptr{unix.CAP_SYS_ADMIN},
+		Pdeathsig:   syscall.SIGKILL,
+	}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		return fmt.Errorf("unable to start sandbox, are unprivileged user namespaces enabled? %w", err)
+	}
+	r.Close()
+	if err := json.NewEncoder(w).Encode(childConfig{Cmd: *c, Root: root, Cwd: cwd}); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	w.Close()
+	return cmd.Wait()
+}
+
+// Init takes over when sap was started to set up a sandbox and never
+// returns in that case. It must run first thing in main.
+func Init() {
+	if len(os.Args) == 0 || os.Args[0] != initArg {
+		return
+	}
+	// prctl and seccomp apply to the calling thread, which is also the one
+	// that executes the script
+	runtime.LockOSThread()
+	if err := child(); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+		os.Exit(125)
+	}
+}
+
+// child runs inside the new namespaces.
+func child() error {
+	f := os.NewFile(3, "sandbox-config")
+	var cfg childConfig
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return fmt.Errorf("unable to read sandbox config: %w", err)
+	}
+	f.Close()
+	c, root := cfg.Cmd, cfg.Root
+	// the script arrives after the config, move it to where a script passed
+	// without a sandbox is found
+	if err := unix.Dup3(4, 3, 0); err == nil {
+		unix.Close(4)
+	}
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("unable to make mounts private: %w", err)
+	}
+	if err := unix.Mount("/", root, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("unable to bind root: %w", err)
+	}
+	if err := remountReadOnly(root); err != nil {
+		return err
+	}
+
+	// a private /tmp, with the script bound back in when it lives there
+	if err := unix.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("unable to mount /tmp: %w", err)
+	}
+	if c.Script != "" && within(c.Script, []string{"/tmp"}) {
+		if err := bind(c.Script, root, true); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.Policy.Writable {
+		if err := bind(w, root, false); err != nil {
+			return err
+		}
+	}
+
+	// a fresh proc for the new pid namespace, the bound one shows the host
+	// processes
+	if err := unix.Mount("proc", filepath.Join(root, "proc"), "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("unable to mount /proc: %w", err)
+	}
+	if err := unix.Sethostname([]byte("sap-sandbox")); err != nil {
+		return err
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("unable to pivot root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("unable to detach host root: %w", err)
+	}
+	if err := os.Chdir(cfg.Cwd); err != nil {
+		return err
+	}
+
+	if err := setLimits(c.Policy.Limits); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	if err := loadSeccomp(); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(c.Args[0])
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, c.Args, c.Env)
+}
+
+// mountFlags maps statfs flags to the mount flags that have to be kept when
+// remounting, the kernel refuses to clear them inside a user namespace.
+var mountFlags = map[int64]uintptr{
+	unix.ST_NOSUID:     unix.MS_NOSUID,
+	unix.ST_NODEV:      unix.MS_NODEV,
+	unix.ST_NOEXEC:     unix.MS_NOEXEC,
+	unix.ST_NOATIME:    unix.MS_NOATIME,
+	unix.ST_NODIRATIME: unix.MS_NODIRATIME,
+	unix.ST_RELATIME:   unix.MS_RELATIME,
+}
+
+// remountReadOnly makes root and every mount below it read-only.
+func remountReadOnly(root string) error {
+	mounts, err := mountPoints(root)
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		var st unix.Statfs_t
+		if err := unix.Statfs(m, &st); err != nil {
+			if m == root {
+				return err
+			}
+			continue
+		}
+		flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+		for flag, ms := range mountFlags {
+			if int64(st.Flags)&flag != 0 {
+				flags |= ms
+			}
+		}
+		if err := unix.Mount("", m, "", flags, ""); err != nil && !kernelFS(strings.TrimPrefix(m, root)) {
+			return fmt.Errorf("unable to make %s read-only: %w", strings.TrimPrefix(m, root), err)
+		}
+	}
+	return nil
+}
+
+// kernelFS reports whether a mount point belongs to /proc or /sys, whose
+// submounts can not always be remounted and are read-only to the user.
+func kernelFS(m string) bool {
+	return within(m, []string{"/proc", "/sys"})
+}
+
+// mountPoints lists root and the mounts below it, parents first.
+func mountPoints(root string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		m := unescapeMount(fields[4])
+		if within(m, []string{root}) {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, s.Err()
+}
+
+// unescapeMount decodes the octal escapes of a mountinfo path.
+func unescapeMount(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			var c byte
+			if _, err := fmt.Sscanf(s[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// bind makes the host path visible at the same place below root.
+func bind(path, root string, readOnly bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(root, path)
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0600); err == nil {
+			f.Close()
+		}
+	}
+	// the target already exists when it is on the read-only root
+	if err != nil && !errors.Is(err, unix.EROFS) && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("unable to bind %s: %w", path, err)
+	}
+	if err := unix.Mount(path, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("unable to bind %s: %w", path, err)
+	}
+	if readOnly {
+		if err := unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+			return fmt.Errorf("unable to make %s read-only: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// setLimits applies the resource limits. RLIMIT_NPROC counts every process
+// of the real user id, those running outside the sandbox included, so the
+// process limit must leave room for them.
+func setLimits(l Limits) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, l.CPUSeconds},
+		{unix.RLIMIT_AS, l.MemoryMB << 20},
+		{unix.RLIMIT_NPROC, l.Processes},
+		{unix.RLIMIT_FSIZE, l.FileSizeMB << 20},
+	}
+	for _, rl := range limits {
+		if rl.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(rl.resource, &unix.Rlimit{Cur: rl.value, Max: rl.value}); err != nil {
+			return fmt.Errorf("unable to set resource limit: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary set up sandboxes, Run executes it again.
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+func TestRunScript(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("no sandbox on this architecture")
+	}
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	// Something to connect to on the host network
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	// Writable paths outside /tmp, which the sandbox replaces
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mkdir := func() string {
+		dir, err := os.MkdirTemp(wd, "sandbox-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return dir
+	}
+
+	// The script reports what it managed to do in the writable directory
+	script := `
+echo > "$ALLOWED/written"
+{ echo > "$FORBIDDEN/written"; } 2>/dev/null && echo > "$ALLOWED/forbidden-written"
+{ echo > /dev/tcp/127.0.0.1/` + port + `; } 2>/dev/null && echo > "$ALLOWED/connected"
+exit 0
+`
+	tests := []struct {
+		name    string
+		network bool
+	}{
+		{"no network", false},
+		{"network", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, forbidden := mkdir(), mkdir()
+			c := &Cmd{
+				Args:   []string{bash, "-c", script},
+				Env:    []string{"PATH=" + os.Getenv("PATH"), "ALLOWED=" + allowed, "FORBIDDEN=" + forbidden},
+				Policy: Policy{Network: tt.network, Writable: []string{allowed}},
+			}
+			if err := c.Run(); err != nil {
+				// The sandbox exits with 125 when it cannot be set up
+				var exit *exec.ExitError
+				if (errors.As(err, &exit) && exit.ExitCode() == 125) || strings.Contains(err.Error(), "user namespaces") {
+					t.Skipf("unable to create a sandbox here: %v", err)
+				}
+				t.Fatal(err)
+			}
+			exists := func(dir, name string) bool {
+				_, err := os.Stat(filepath.Join(dir, name))
+				return err == nil
+			}
+			if !exists(allowed, "written") {
+				t.Error("the script could not write to the writable path")
+			}
+			if exists(allowed, "forbidden-written") || exists(forbidden, "written") {
+				t.Error("the script wrote outside the writable paths")
+			}
+			if got := exists(allowed, "connected"); got != tt.network {
+				t.Errorf("the script connected to the host: %t, want %t", got, tt.network)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		file string
+		dirs []string
+		want bool
+	}{
+		{"/opt/app", []string{"/opt/app"}, true},
+		{"/opt/app/data", []string{"/opt/app"}, true},
+		{"/opt/app/data", []string{"/opt/app/"}, true},
+		{"/opt/app/../../etc", []string{"/opt/app"}, false},
+		{"/opt/application", []string{"/opt/app"}, false},
+		{"/opt", []string{"/opt/app"}, false},
+		{"/etc/passwd", []string{"/tmp", "/opt"}, false},
+		{"/etc/passwd", []string{"/tmp", "/"}, true},
+		{"/opt/app", nil, false},
+	}
+	for _, tt := range tests {
+		if got := within(tt.file, tt.dirs); got != tt.want {
+			t.Errorf("within(%s, %q) = %t, want %t", tt.file, tt.dirs, got, tt.want)
+		}
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	declared := Policy{
+		Network:  true,
+		Writable: []string{"/opt/app", "/var/lib/app/", "/etc"},
+		Limits:   Limits{CPUSeconds: 600, MemoryMB: 512},
+	}
+	tests := []struct {
+		name   string
+		policy Policy
+		r      Restrictions
+		want   Policy
+	}{
+		{
+			name:   "declared writes need an opt in",
+			policy: declared,
+			want:   Policy{Network: true, Limits: declared.Limits},
+		},
+		{
+			name:   "writes within the allowed paths",
+			policy: declared,
+			r:      Restrictions{Writable: []string{"/opt", "/var/lib/app"}},
+			want:   Policy{Network: true, Writable: []string{"/opt/app", "/var/lib/app"}, Limits: declared.Limits},
+		},
+		{
+			name:   "no network",
+			policy: declared,
+			r:      Restrictions{NoNetwork: true, Writable: []string{"/"}},
+			want:   Policy{Writable: []string{"/opt/app", "/var/lib/app", "/etc"}, Limits: declared.Limits},
+		},
+		{
+			name:   "lower limits",
+			policy: declared,
+			r:      Restrictions{Limits: Limits{CPUSeconds: 60, MemoryMB: 1024, Processes: 10}},
+			want:   Policy{Network: true, Limits: Limits{CPUSeconds: 60, MemoryMB: 512, Processes: 10}},
+		},
+		{
+			// Restrictions never grant what the signer did not declare
+			name: "nothing declared",
+			r:    Restrictions{Writable: []string{"/"}},
+			want: Policy{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Restrict(tt.r); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Restrict = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Policy{Writable: []string{"/opt/app"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (Policy{Writable: []string{"opt/app"}}).Validate(); err == nil {
+		t.Error("Validate of a relative writable path succeeded")
+	}
+}
@@ -0,0 +1,129 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+
+	// offsets into struct seccomp_data
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArg0 = 16
+)
+
+// deniedSyscalls fail with EPERM inside the sandbox. They change the
+// system or the sandbox itself rather than doing the work of a script.
+// Numbers only some architectures define are in archDeniedSyscalls.
+var deniedSyscalls = append([]uintptr{
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_FSOPEN,
+	unix.SYS_FSMOUNT,
+	unix.SYS_FSCONFIG,
+	unix.SYS_FSPICK,
+	unix.SYS_MOVE_MOUNT,
+	unix.SYS_OPEN_TREE,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_REBOOT,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_ACCT,
+	unix.SYS_SETTIMEOFDAY,
+	unix.SYS_CLOCK_SETTIME,
+	unix.SYS_CLOCK_ADJTIME,
+	unix.SYS_ADJTIMEX,
+	unix.SYS_QUOTACTL,
+	unix.SYS_SYSLOG,
+	unix.SYS_SETHOSTNAME,
+	unix.SYS_SETDOMAINNAME,
+}, archDeniedSyscalls...)
+
+// namespaceFlags are the clone flags that would create new namespaces.
+const namespaceFlags = unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWNET |
+	unix.CLONE_NEWUTS | unix.CLONE_NEWIPC | unix.CLONE_NEWCGROUP
+
+// loadSeccomp installs the filter on the calling thread. no_new_privs must
+// already be set.
+func loadSeccomp() error {
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("unable to load seccomp filter: %w", err)
+	}
+	return nil
+}
+
+// seccompFilter builds the BPF program. Calls from another architecture
+// kill the process, clone3 reports ENOSYS so libc falls back to clone, and
+// clone may not create namespaces.
+func seccompFilter() []unix.SockFilter {
+	load := func(offset uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+	ret := func(k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: k}
+	}
+	jeq := func(k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: k, Jt: jt, Jf: jf}
+	}
+	eperm := ret(seccompRetErrno | uint32(unix.EPERM))
+
+	f := []unix.SockFilter{
+		load(offsetArch),
+		jeq(auditArch, 1, 0),
+		ret(seccompRetKillProcess),
+		load(offsetNr),
+	}
+	f = append(f, archFilter(eperm)...)
+	f = append(f,
+		jeq(uint32(unix.SYS_CLONE3), 0, 1),
+		ret(seccompRetErrno|uint32(unix.ENOSYS)),
+		jeq(uint32(unix.SYS_CLONE), 0, 4),
+		load(offsetArg0),
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, K: namespaceFlags, Jt: 0, Jf: 1},
+		eperm,
+		ret(seccompRetAllow),
+	)
+	for _, nr := range deniedSyscalls {
+		f = append(f, jeq(uint32(nr), 0, 1), eperm)
+	}
+	return append(f, ret(seccompRetAllow))
+}
@@ -0,0 +1,113 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"encoding/binary"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompData lays out struct seccomp_data for the syscall nr with arch and
+// first argument arg0.
+func seccompData(nr uint32, arch uint32, arg0 uint64) []byte {
+	data := make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[offsetNr:], nr)
+	binary.LittleEndian.PutUint32(data[offsetArch:], arch)
+	binary.LittleEndian.PutUint64(data[offsetArg0:], arg0)
+	return data
+}
+
+// runFilter interprets the instructions seccompFilter uses over data and
+// returns the action the kernel would take.
+func runFilter(t *testing.T, filter []unix.SockFilter, data []byte) uint32 {
+	t.Helper()
+	var a uint32
+	for pc := 0; pc < len(filter); pc++ {
+		ins := filter[pc]
+		jump := func(cond bool) {
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		}
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			a = binary.LittleEndian.Uint32(data[ins.K:])
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			jump(a == ins.K)
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			jump(a >= ins.K)
+		case unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			jump(a&ins.K != 0)
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatal("the filter has no return")
+	return 0
+}
+
+// seccompCase is a system call and the action the filter should take on it.
+// A zero arch is the architecture sap runs on.
+type seccompCase struct {
+	name string
+	nr   uint32
+	arch uint32
+	arg0 uint64
+	want uint32
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if auditArch == 0 {
+		t.Skip("no seccomp filter on " + runtime.GOARCH)
+	}
+	eperm := uint32(seccompRetErrno | unix.EPERM)
+	tests := []seccompCase{
+		{name: "allowed", nr: unix.SYS_GETPID, want: seccompRetAllow},
+		{name: "denied", nr: unix.SYS_MOUNT, want: eperm},
+		{name: "other architecture", nr: unix.SYS_GETPID, arch: 0x40000003, want: seccompRetKillProcess},
+		{name: "clone3", nr: unix.SYS_CLONE3, want: seccompRetErrno | uint32(unix.ENOSYS)},
+		{name: "clone a thread", nr: unix.SYS_CLONE, arg0: unix.CLONE_VM | unix.CLONE_FS | unix.CLONE_FILES | unix.CLONE_SIGHAND | unix.CLONE_THREAD, want: seccompRetAllow},
+		{name: "clone a user namespace", nr: unix.SYS_CLONE, arg0: unix.CLONE_NEWUSER, want: eperm},
+		{name: "clone a network namespace", nr: unix.SYS_CLONE, arg0: unix.CLONE_NEWNET | uint64(unix.SIGCHLD), want: eperm},
+		{name: "clone a mount namespace", nr: unix.SYS_CLONE, arg0: unix.CLONE_NEWNS, want: eperm},
+	}
+	if runtime.GOARCH == "amd64" {
+		// x32 calls share the x86_64 audit architecture
+		tests = append(tests, seccompCase{name: "x32", nr: 0x40000000 | unix.SYS_GETPID, want: eperm})
+	}
+	for _, nr := range deniedSyscalls {
+		tests = append(tests, seccompCase{name: "denied " + strconv.Itoa(int(nr)), nr: uint32(nr), want: eperm})
+	}
+
+	filter := seccompFilter()
+	for _, tt := range tests {
+		arch := tt.arch
+		if arch == 0 {
+			arch = auditArch
+		}
+		if got := runFilter(t, filter, seccompData(tt.nr, arch, tt.arg0)); got != tt.want {
+			t.Errorf("%s: filter returned %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
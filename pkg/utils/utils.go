@@ -1,22 +1,18 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
-)
+	"path"
+	"strings"
 
-// Generate a temp directory prepended with .sigstore
-func StoreDir(timeStamp string) (string, error)  {
-	storeDir := filepath.Join(".", ".sigstore", timeStamp)
-	err := os.MkdirAll(storeDir, 0755)
-	if err != nil {
-		return "", err
-	}
-	return storeDir, nil
-}
+	"github.com/lukehinds/sap/pkg/download"
+	"github.com/lukehinds/sap/pkg/sandbox"
+)
 
 // Generate a temp directory prepended with .sigstore
 //func TmpDir() (string, error)  {
@@ -32,25 +28,31 @@ func StoreDir(timeStamp string) (string, error)  {
 //	return dir, nil
 //}
 
-// Download files from rawurls
-// TODO: May want to replace this
-func DownloadFile(file string, url string) error {
-	// Get the data
-	resp, err := http.Get(url)
+// Download files from rawurls within the download limits, the content is
+// checked against want before it is written to file, which must not exist
+func DownloadFile(ctx context.Context, file string, url string, want download.Digests) error {
+	content, err := download.Fetch(ctx, http.DefaultClient, url, nil, want)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	return download.WriteFile(file, content)
+}
 
-	out, err := os.Create(file)
+// GitFileMode returns the git tree mode of a local file, 100755 when it is
+// executable. Symlinks are followed, as the content they point to is what
+// gets signed and committed
+func GitFileMode(file string) (string, error) {
+	info, err := os.Stat(file)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer out.Close()
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	return err
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", file)
+	}
+	if info.Mode().Perm()&0111 != 0 {
+		return "100755", nil
+	}
+	return "100644", nil
 }
 
 // Opens a file for reading
@@ -71,19 +73,115 @@ func ReadFile(fileName string) ([]byte, error) {
 	return b, nil
 }
 
-// Execute the targeted script to stdout|in|err
-func ExecScript(scriptName string) (error) {
-	err := os.Chmod(scriptName, 0700)
+// ScriptFD is the path interpreters read the script from when it is handed
+// over as an open file
+const ScriptFD = "/dev/fd/3"
+
+// Create a private directory for a single run. MkdirTemp picks a fresh name
+// and fails rather than reuse an existing path, so no one else can have
+// prepared it
+func TempDir() (string, error) {
+	dir, err := os.MkdirTemp("", "sap-")
 	if err != nil {
-		return err
+		return "", err
 	}
-	cmd := exec.Command("bash", "-c", scriptName)
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0700 {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("temporary directory %s is not private", dir)
+	}
+	return dir, nil
+}
+
+// Open the script once, the returned content is what gets verified and the
+// file is what gets executed, as ScriptFD. Symlinks are refused
+func OpenScript(file string) (*os.File, []byte, error) {
+	f, err := openNoFollow(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, content, nil
+}
+
+// ExecOptions are passed through to the executed script
+type ExecOptions struct {
+	// Args are appended to the script command line
+	Args []string
+	// Env is the complete environment of the script
+	Env []string
+	// Dir is the working directory, the current one when empty
+	Dir string
+	// Sandbox runs the script in a sandbox with this policy when set
+	Sandbox *sandbox.Policy
+	// Script is the open script, readable by the interpreter as ScriptFD
+	Script *os.File
+}
+
+// Execute the targeted script to stdout|in|err, command is the interpreter
+// command line ending with the script
+func ExecScript(command []string, opts ExecOptions) (error) {
+	// The script was read to the end for verification, and opening ScriptFD
+	// shares that offset on darwin and the BSDs
+	if opts.Script != nil {
+		if _, err := opts.Script.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if opts.Sandbox != nil {
+		sandboxed := &sandbox.Cmd{
+			Args:       append(append([]string{}, command...), opts.Args...),
+			Script:     command[len(command)-1],
+			ScriptFile: opts.Script,
+			Env:        append([]string{}, opts.Env...),
+			Dir:        opts.Dir,
+			Policy:     *opts.Sandbox,
+		}
+		return sandboxed.Run()
+	}
+	cmd := exec.Command(command[0], append(command[1:], opts.Args...)...)
+	if opts.Script != nil {
+		cmd.ExtraFiles = []*os.File{opts.Script}
+	}
+	// a nil Env would hand the script our whole environment
+	cmd.Env = append([]string{}, opts.Env...)
+	cmd.Dir = opts.Dir
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	err := cmd.Run()
 	if err != nil {
 		return err
 	}
 	return nil
+}
+
+// FilterEnv keeps the NAME=value pairs of environ whose name matches one of
+// the allow patterns, or all of them when allow is empty, and drops the ones
+// matching a deny pattern. Patterns use path.Match syntax, such as LC_*
+func FilterEnv(environ []string, allow []string, deny []string) []string {
+	env := []string{}
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if (len(allow) == 0 || matchAny(allow, name)) && !matchAny(deny, name) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
 }
\ No newline at end of file
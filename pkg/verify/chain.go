@@ -0,0 +1,102 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadRoots reads a PEM bundle of trusted Fulcio root certificates from path.
+func LoadRoots(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, errors.New("no Fulcio root bundle configured, set --fulcio-root or fulcio-root in the config file")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := ParseCertificates(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in Fulcio root bundle %s", path)
+	}
+	roots := x509.NewCertPool()
+	for _, c := range certs {
+		roots.AddCert(c)
+	}
+	return roots, nil
+}
+
+// ParseCertificates decodes every PEM encoded certificate in b. Blocks that
+// are not certificates are skipped.
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// CertificateChain checks that cert was issued for code signing and chains to
+// one of roots, using intermediates as untrusted intermediate certificates.
+// The chain is evaluated at signedAt, as Fulcio certificates are short lived
+// and will usually have expired by the time a script is installed.
+func CertificateChain(cert *x509.Certificate, intermediates []*x509.Certificate, roots *x509.CertPool, signedAt time.Time) error {
+	if cert == nil {
+		return errors.New("no signing certificate")
+	}
+	if roots == nil {
+		return errors.New("no trusted roots")
+	}
+	if signedAt.Before(cert.NotBefore) || signedAt.After(cert.NotAfter) {
+		return fmt.Errorf("signing time %s is outside the certificate validity period %s - %s",
+			signedAt.UTC().Format(time.RFC3339), cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return fmt.Errorf("certificate chain verification failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"github.com/lukehinds/sap/pkg/keys"
+)
+
+// oidIssuer is the Fulcio certificate extension holding the OIDC issuer that
+// authenticated the signer.
+var oidIssuer = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+
+// Identity is the signer identity embedded in a Fulcio certificate, or the
+// fingerprint of the key that signed a script.
+type Identity struct {
+	Emails []string
+	URIs   []string
+	Issuer string
+	Key    string
+}
+
+// SignerIdentity extracts the SAN emails, SAN URIs and OIDC issuer from cert.
+func SignerIdentity(cert *x509.Certificate) Identity {
+	id := Identity{Emails: cert.EmailAddresses}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuer) {
+			id.Issuer = string(ext.Value)
+		}
+	}
+	return id
+}
+
+// KeyIdentity is the identity of scripts signed with pub.
+func KeyIdentity(pub crypto.PublicKey) (Identity, error) {
+	id, err := keys.ID(pub)
+	return Identity{Key: id}, err
+}
+
+// Subjects returns every SAN value of the identity, or the key fingerprint.
+func (id Identity) Subjects() []string {
+	if id.Key != "" {
+		return []string{id.Key}
+	}
+	return append(append([]string{}, id.Emails...), id.URIs...)
+}
+
+// String returns a human readable form of the identity.
+func (id Identity) String() string {
+	s := strings.Join(id.Subjects(), ", ")
+	if id.Issuer != "" {
+		s += " (issuer " + id.Issuer + ")"
+	}
+	return s
+}
+
+// IdentityPolicy lists the signers allowed to sign scripts for an owner/repo.
+// An empty list places no restriction on that part of the identity.
+type IdentityPolicy struct {
+	Owner      string   `mapstructure:"owner"`
+	Repo       string   `mapstructure:"repo"`
+	Identities []string `mapstructure:"identities"`
+	Issuers    []string `mapstructure:"issuers"`
+}
+
+// Matches reports whether the policy applies to owner/repo. A policy without
+// a repo applies to every repo of the owner.
+func (p IdentityPolicy) Matches(owner, repo string) bool {
+	if !strings.EqualFold(p.Owner, owner) {
+		return false
+	}
+	return p.Repo == "" || strings.EqualFold(p.Repo, repo)
+}
+
+// Empty reports whether the policy places no restriction on the signer.
+func (p IdentityPolicy) Empty() bool {
+	return len(p.Identities) == 0 && len(p.Issuers) == 0
+}
+
+// Merge returns a policy allowing the signers of both p and o.
+func (p IdentityPolicy) Merge(o IdentityPolicy) IdentityPolicy {
+	return IdentityPolicy{
+		Owner:      p.Owner,
+		Repo:       p.Repo,
+		Identities: append(append([]string{}, p.Identities...), o.Identities...),
+		Issuers:    append(append([]string{}, p.Issuers...), o.Issuers...),
+	}
+}
+
+// Check returns an error describing the mismatch when id is not allowed by
+// the policy.
+func (p IdentityPolicy) Check(id Identity) error {
+	if len(p.Identities) > 0 && !containsAny(p.Identities, id.Subjects()) {
+		return fmt.Errorf("signer identity [%s] is not one of the allowed identities [%s]",
+			strings.Join(id.Subjects(), ", "), strings.Join(p.Identities, ", "))
+	}
+	if len(p.Issuers) > 0 {
+		if id.Key != "" {
+			return fmt.Errorf("script is signed with key %s, which has no OIDC issuer, allowed issuers are [%s]",
+				id.Key, strings.Join(p.Issuers, ", "))
+		}
+		if id.Issuer == "" {
+			return fmt.Errorf("signing certificate does not record an OIDC issuer, allowed issuers are [%s]",
+				strings.Join(p.Issuers, ", "))
+		}
+		if !containsAny(p.Issuers, []string{id.Issuer}) {
+			return fmt.Errorf("signer OIDC issuer %s is not one of the allowed issuers [%s]",
+				id.Issuer, strings.Join(p.Issuers, ", "))
+		}
+	}
+	return nil
+}
+
+func containsAny(allowed, values []string) bool {
+	for _, a := range allowed {
+		for _, v := range values {
+			if a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
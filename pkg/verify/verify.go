@@ -0,0 +1,290 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/lukehinds/sap/pkg/interpreter"
+	"github.com/lukehinds/sap/pkg/keys"
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/lukehinds/sap/pkg/rekor"
+)
+
+// Class groups checks so callers can tell apart why verification failed.
+type Class string
+
+// Verification classes, in the order they are checked.
+const (
+	ClassMaterials   Class = "materials"
+	ClassCertificate Class = "certificate"
+	ClassIdentity    Class = "identity"
+	ClassSignature   Class = "signature"
+	ClassInterpreter Class = "interpreter"
+	ClassTlog        Class = "transparency log"
+)
+
+// ExitCode returns the process exit code used for a failure of the class.
+func (c Class) ExitCode() int {
+	switch c {
+	case ClassMaterials:
+		return 2
+	case ClassSignature:
+		return 3
+	case ClassCertificate:
+		return 4
+	case ClassIdentity:
+		return 5
+	case ClassTlog:
+		return 6
+	case ClassInterpreter:
+		return 7
+	}
+	return 1
+}
+
+// Materials are everything needed to verify one signed script.
+type Materials struct {
+	Manifest          []byte
+	ManifestSignature []byte
+	Artifact          manifest.Artifact
+	Script            []byte
+	Certificate       []byte
+	Chain             []byte
+	Signature         []byte
+	Entry             []byte
+}
+
+// Options configure which roots, signers and log are trusted.
+type Options struct {
+	Roots          *x509.CertPool
+	Policy         IdentityPolicy
+	RekorPublicKey crypto.PublicKey
+	// PublicKey verifies scripts signed with a key. When set, keyless
+	// scripts are refused.
+	PublicKey crypto.PublicKey
+	// RekorURL is the log to look the entry up in. When empty the entry is
+	// only checked against the committed inclusion proof, whose root hash is
+	// not signed: the signed entry timestamp is then the only proof that
+	// the log accepted the entry.
+	RekorURL string
+	// Interpreters is the allowlist of interpreters scripts may declare.
+	// When empty every registered interpreter is allowed.
+	Interpreters []string
+	// AllowAnySigner accepts keyless scripts without an identity policy.
+	// Otherwise they are refused when Policy is empty.
+	AllowAnySigner bool
+}
+
+// Check is the outcome of a single verification step.
+type Check struct {
+	Class Class
+	Name  string
+	Err   error
+}
+
+// Report lists the checks that were run. Verification stops at the first
+// failing check.
+type Report struct {
+	Checks      []Check
+	Signer      Identity
+	Entry       *rekor.Entry
+	Interpreter interpreter.Handler
+}
+
+// Passed reports whether every check passed.
+func (r *Report) Passed() bool {
+	return r.Failure() == nil
+}
+
+// Failure returns the failed check, or nil.
+func (r *Report) Failure() *Check {
+	for i := range r.Checks {
+		if r.Checks[i].Err != nil {
+			return &r.Checks[i]
+		}
+	}
+	return nil
+}
+
+// check records the outcome of a step and reports whether it passed.
+func (r *Report) check(class Class, name string, err error) bool {
+	r.Checks = append(r.Checks, Check{Class: class, Name: name, Err: err})
+	return err == nil
+}
+
+// Verify runs the signature, certificate chain, identity and transparency
+// log checks over the materials of a script.
+func Verify(m *Materials, opts Options) *Report {
+	r := &Report{}
+
+	keyMode := m.Artifact.Mode == manifest.ModeKey
+	var cert *x509.Certificate
+	var err error
+	if !keyMode {
+		cert, err = parseLeaf(m.Certificate)
+		if !r.check(ClassMaterials, "parse signing certificate", err) {
+			return r
+		}
+	}
+	entry, err := rekor.ParseEntry(m.Entry)
+	if !r.check(ClassMaterials, "parse transparency log entry", err) {
+		return r
+	}
+	r.Entry = entry
+	sig, err := DecodeSignature(m.Signature)
+	if !r.check(ClassMaterials, "decode signature", err) {
+		return r
+	}
+	manifestSig, err := DecodeSignature(m.ManifestSignature)
+	if !r.check(ClassMaterials, "decode manifest signature", err) {
+		return r
+	}
+
+	// The transparency log entry provides the trusted signing time, so
+	// check Rekor's signed promise before anything relies on it
+	if opts.RekorPublicKey == nil {
+		r.check(ClassTlog, "signed entry timestamp", errors.New("no Rekor public key configured"))
+		return r
+	}
+	if !r.check(ClassTlog, "signed entry timestamp", entry.VerifySET(opts.RekorPublicKey)) {
+		return r
+	}
+
+	// Find the signing key: the configured public key for scripts signed
+	// with a key, or the key of a Fulcio certificate that was issued by a
+	// trusted root and valid at the time the script was signed
+	var (
+		pub    crypto.PublicKey
+		pubPEM []byte
+	)
+	switch {
+	case keyMode && opts.PublicKey == nil:
+		r.check(ClassCertificate, "public key", errors.New("script is signed with a key but no public key is configured"))
+		return r
+	case keyMode:
+		pub = opts.PublicKey
+		if pubPEM, err = keys.MarshalPublicKey(pub); !r.check(ClassCertificate, "public key", err) {
+			return r
+		}
+		if r.Signer, err = KeyIdentity(pub); !r.check(ClassCertificate, "public key", err) {
+			return r
+		}
+	case opts.PublicKey != nil:
+		r.check(ClassCertificate, "signing mode", errors.New("script is signed keyless but only a public key is trusted"))
+		return r
+	default:
+		intermediates, err := ParseCertificates(m.Chain)
+		if !r.check(ClassMaterials, "parse certificate chain", err) {
+			return r
+		}
+		if !r.check(ClassCertificate, "certificate chain", CertificateChain(cert, intermediates, opts.Roots, entry.Time())) {
+			return r
+		}
+		pub, pubPEM = cert.PublicKey, m.Certificate
+		r.Signer = SignerIdentity(cert)
+	}
+
+	// Only accept scripts signed by an identity the policy allows. The
+	// configured key names the signer of keyed scripts, anyone can get a
+	// Fulcio certificate
+	switch {
+	case !opts.Policy.Empty():
+		if !r.check(ClassIdentity, "signer identity", opts.Policy.Check(r.Signer)) {
+			return r
+		}
+	case opts.PublicKey == nil && !opts.AllowAnySigner:
+		r.check(ClassIdentity, "signer identity", errors.New("no identity policy allows the signer "+r.Signer.String()))
+		return r
+	}
+
+	// Only trust the manifest once it is known to come from the signer
+	if !r.check(ClassSignature, "manifest signature", Signature(pub, m.Manifest, manifestSig)) {
+		return r
+	}
+	if !r.check(ClassIdentity, "manifest signer", checkManifestSigner(m.Artifact.Signer, r.Signer)) {
+		return r
+	}
+	// The script only runs under the interpreter the signer declared
+	r.Interpreter, err = interpreter.Check(m.Artifact.Interpreter, opts.Interpreters)
+	if !r.check(ClassInterpreter, "declared interpreter", err) {
+		return r
+	}
+	digest := sha256.Sum256(m.Script)
+	if hex.EncodeToString(digest[:]) != m.Artifact.SHA256 {
+		r.check(ClassSignature, "script digest", fmt.Errorf("sha256 of %s does not match the manifest", m.Artifact.Path))
+		return r
+	}
+	if !r.check(ClassSignature, "script signature", Signature(pub, m.Script, sig)) {
+		return r
+	}
+
+	// Check the signature was logged and the log still contains it
+	if entry.UUID != m.Artifact.Rekor.UUID || entry.LogIndex != m.Artifact.Rekor.LogIndex {
+		r.check(ClassTlog, "log entry", errors.New("transparency log entry does not match the manifest"))
+		return r
+	}
+	if !r.check(ClassTlog, "log entry contents", entry.VerifyBody(pubPEM, sig, m.Script)) {
+		return r
+	}
+	if !r.check(ClassTlog, "inclusion proof", entry.VerifyInclusion()) {
+		return r
+	}
+	if opts.RekorURL == "" {
+		return r
+	}
+	logged, err := rekor.Fetch(opts.RekorURL, entry.UUID)
+	if err == nil && (logged.Body != entry.Body || logged.LogIndex != entry.LogIndex) {
+		err = fmt.Errorf("transparency log entry %s does not match the committed entry", entry.UUID)
+	}
+	if !r.check(ClassTlog, "log lookup", err) {
+		return r
+	}
+	r.check(ClassTlog, "log consistency", entry.VerifyConsistency(opts.RekorURL, opts.RekorPublicKey))
+	return r
+}
+
+// parseLeaf parses the first certificate of a PEM file.
+func parseLeaf(b []byte) (*x509.Certificate, error) {
+	certs, err := ParseCertificates(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM data found in signing certificate")
+	}
+	return certs[0], nil
+}
+
+// checkManifestSigner makes sure the signer recorded in the manifest is the
+// identity in the signing certificate.
+func checkManifestSigner(recorded manifest.Signer, actual Identity) error {
+	subjects := actual.Subjects()
+	if recorded.Issuer != actual.Issuer || len(recorded.Identities) != len(subjects) {
+		return fmt.Errorf("manifest signer does not match signing certificate identity %s", actual)
+	}
+	for i := range subjects {
+		if recorded.Identities[i] != subjects[i] {
+			return fmt.Errorf("manifest signer does not match signing certificate identity %s", actual)
+		}
+	}
+	return nil
+}
